@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"stegogo/lib"
+
+	"github.com/spf13/cobra"
+)
+
+// appendCmd represents the append command
+var appendCmd = &cobra.Command{
+	Use:   "append",
+	Short: "Trailing data",
+	Long: `Append data after the end of an image stream (after PNG IEND, JPEG EOI, the GIF trailer
+or the declared size of a BMP). Image viewers ignore it, so the image stays valid.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		os.Exit(1)
+	},
+}
+
+var appendEmbedCmd = &cobra.Command{
+	Use:   "embed",
+	Short: "Embed data",
+	Long:  "Append a file after the end of an image.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		secret_file_path, _ := cmd.Flags().GetString("secret")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		if output_file_path == "" {
+			output_file_path = "output" + filepath.Ext(input_file_path)
+		}
+
+		// Read input
+		input_bytes, err := ioutil.ReadFile(input_file_path)
+		if err != nil {
+			return err
+		}
+		secret_bytes, err := ioutil.ReadFile(secret_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Write to file
+		output_bytes, err := lib.AppendTrailingData(input_bytes, secret_bytes)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+	},
+}
+
+var appendExtractCmd = &cobra.Command{
+	Use:   "extract",
+	Short: "Detect and extract data",
+	Long:  "Find the true end of an image stream, and extract anything after it.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		output_file_path, _ := cmd.Flags().GetString("output")
+
+		// Find end of image
+		input_bytes, err := ioutil.ReadFile(input_file_path)
+		if err != nil {
+			return err
+		}
+		end, err := lib.FindImageEnd(input_bytes)
+		if err != nil {
+			return err
+		}
+
+		// Report and write to file
+		trailing_bytes := input_bytes[end:]
+		if len(trailing_bytes) == 0 {
+			fmt.Printf("Image ends at offset %d, with no trailing data.\n", end)
+			return nil
+		}
+		fmt.Printf("Image ends at offset %d, followed by %d bytes of trailing data.\n", end, len(trailing_bytes))
+		if file_type := lib.DetectFileType(trailing_bytes); file_type != "" {
+			fmt.Printf("Trailing data looks like: %s\n", file_type)
+		}
+		return ioutil.WriteFile(output_file_path, trailing_bytes, 0644)
+	},
+}
+
+func init() {
+	// Add commands
+	rootCmd.AddCommand(appendCmd)
+	appendCmd.AddCommand(appendEmbedCmd)
+	appendCmd.AddCommand(appendExtractCmd)
+
+	// Add flags
+	appendEmbedCmd.Flags().StringP("input", "i", "", "(Required) Input image file.")
+	appendEmbedCmd.Flags().StringP("secret", "s", "", "(Required) A file to be appended to the image.")
+	appendEmbedCmd.Flags().StringP("output", "o", "", "(Default 'output' with the input's extension) Output image path.")
+	appendEmbedCmd.MarkFlagRequired("input")
+	appendEmbedCmd.MarkFlagRequired("secret")
+
+	appendExtractCmd.Flags().StringP("input", "i", "", "(Required) Input image file with appended data.")
+	appendExtractCmd.Flags().StringP("output", "o", "extracted.dat", "(Default 'extracted.dat') Output extracted data file.")
+	appendExtractCmd.MarkFlagRequired("input")
+}
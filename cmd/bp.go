@@ -3,8 +3,6 @@ package cmd
 import (
 	"errors"
 	_ "image/jpeg"
-	"image/png"
-	"log"
 	"os"
 	"stegogo/lib"
 
@@ -44,12 +42,17 @@ var bpEmbedCmd = &cobra.Command{
 		cover_file_path, _ := cmd.Flags().GetString("cover")
 		secret_file_path, _ := cmd.Flags().GetString("secret")
 		output_file_path, _ := cmd.Flags().GetString("output")
+		output_format, _ := cmd.Flags().GetString("format")
 
 		// Open cover_file
 		cover_img, err := lib.OpenImage(cover_file_path)
 		if err != nil {
 			return err
 		}
+		err = lib.CheckCoverDepth(cover_img)
+		if err != nil {
+			return err
+		}
 
 		// Open secret_file
 		secret_img, err := lib.OpenImage(secret_file_path)
@@ -63,14 +66,7 @@ var bpEmbedCmd = &cobra.Command{
 		}
 
 		// Write image to file
-		out_file, err := os.Create(output_file_path)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer out_file.Close()
-		png.Encode(out_file, new_img)
-
-		return nil
+		return lib.SaveImageLike(new_img, output_file_path, output_format, cover_file_path)
 	},
 }
 
@@ -89,6 +85,7 @@ var bpExtractCmd = &cobra.Command{
 		// Parse input flags
 		input_file_path, _ := cmd.Flags().GetString("input")
 		output_file_path, _ := cmd.Flags().GetString("output")
+		output_format, _ := cmd.Flags().GetString("format")
 
 		// Open input file
 		input_img, err := lib.OpenImage(input_file_path)
@@ -103,14 +100,7 @@ var bpExtractCmd = &cobra.Command{
 		}
 
 		// Write image to file
-		out_file, err := os.Create(output_file_path)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer out_file.Close()
-		png.Encode(out_file, new_img)
-
-		return nil
+		return lib.SaveImage(new_img, output_file_path, output_format)
 	},
 }
 
@@ -122,11 +112,13 @@ func init() {
 	bpEmbedCmd.Flags().StringP("cover", "c", "", "(Required) A cover image file for the secret image to be embedded within.")
 	bpEmbedCmd.Flags().StringP("secret", "s", "", "(Required) A one-channel secret image file to be embedded within the cover image.")
 	bpEmbedCmd.Flags().StringP("output", "o", "output.png", "(Default 'output.png') Output image path.")
+	bpEmbedCmd.Flags().String("format", "", "(Optional) Output image format: png, bmp, pgm, ppm, pnm, pam, pgm-plain or ppm-plain. Otherwise, chosen by the output file extension.")
 	bpEmbedCmd.MarkFlagRequired("secret")
 	bpEmbedCmd.MarkFlagRequired("cover")
 
 	bpExtractCmd.Flags().StringP("input", "i", "", "(Required) Input file with embedded data inside.")
 	bpExtractCmd.Flags().StringP("output", "o", "output.png", "Output file for extracted bit plane slice.")
+	bpExtractCmd.Flags().String("format", "", "(Optional) Output image format: png, bmp, pgm, ppm, pnm, pam, pgm-plain or ppm-plain. Otherwise, chosen by the output file extension.")
 	bpEmbedCmd.MarkFlagRequired("input")
 	bpEmbedCmd.MarkFlagRequired("output")
 
@@ -1,15 +1,15 @@
 package cmd
 
 import (
-	"bufio"
-	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"stegogo/lib"
 
 	exif "github.com/dsoprea/go-exif/v3"
 	exifcommon "github.com/dsoprea/go-exif/v3/common"
-	jpeg "github.com/dsoprea/go-jpeg-image-structure/v2"
 	"github.com/spf13/cobra"
 )
 
@@ -17,7 +17,7 @@ import (
 var exifCmd = &cobra.Command{
 	Use:   "exif",
 	Short: "EXIF Data Manipulation",
-	Long:  `Read, write and edit EXIF blocks in an image.`,
+	Long:  `Read, write and edit EXIF blocks in an image, and XMP packets with 'exif xmp'.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		cmd.Help()
 		os.Exit(1)
@@ -27,47 +27,66 @@ var exifCmd = &cobra.Command{
 var exifExtractCmd = &cobra.Command{
 	Use:   "extract",
 	Short: "Extract/read data",
+	Long: `Show every tag in every IFD (IFD0, the EXIF SubIFD, GPS, Interop, IFD1, ...) with typed values,
+or dump the raw bytes of a single tag to a file with --tag. A payload split across several tags
+with 'exif embed --tags' can be put back together and saved with --reassemble.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		input_file_path, _ := cmd.Flags().GetString("image")
 		input_tag, _ := cmd.Flags().GetString("tag")
 		output_file_path, _ := cmd.Flags().GetString("output")
+		as_json, _ := cmd.Flags().GetBool("json")
+		reassemble, _ := cmd.Flags().GetBool("reassemble")
 		rawExif, err := exif.SearchFileAndExtractExif(input_file_path)
 		if err != nil {
 			return err
 		}
 
-		im, err := exifcommon.NewIfdMappingWithStandard()
-		if err != nil {
-			return err
+		if reassemble {
+			// Put a payload split across several tags back together
+			output_bytes, err := lib.ExtractExifChunks(rawExif)
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(output_file_path, output_bytes, 0644)
 		}
 
-		ti := exif.NewTagIndex()
-		_, index, err := exif.Collect(im, ti, rawExif)
+		exifTags, err := lib.ExifTree(rawExif)
 		if err != nil {
 			return err
 		}
 
-		exifTags := index.RootIfd.DumpTags()
-		for _, tag := range exifTags {
-			if tag.IsThumbnailOffset() {
-				continue
+		if input_tag != "" {
+			// Dump first matching tag to file
+			for _, tag := range exifTags {
+				if tag.TagName == input_tag {
+					return ioutil.WriteFile(output_file_path, tag.Raw, 0644)
+				}
 			}
-			bytesArr, err := tag.GetRawBytes()
+			return fmt.Errorf("tag '%s' not found", input_tag)
+		}
+
+		if as_json {
+			json_bytes, err := json.MarshalIndent(exifTags, "", "  ")
 			if err != nil {
 				return err
 			}
-			if input_tag == "" {
-				// If input tag not given, display tag to user
-				if len(bytesArr) > 100 {
-					bytesArr = bytesArr[:100]
-				}
-				fmt.Printf("**********\n%s:\n%s\n", tag.TagName(), string(bytesArr))
-			} else {
-				// Otherwise, dump tag to file
-				if input_tag == tag.TagName() {
-					ioutil.WriteFile(output_file_path, bytesArr, 0644)
-				}
+			fmt.Println(string(json_bytes))
+			return nil
+		}
+
+		// Display tags to user, grouped by IFD
+		current_ifd := ""
+		for _, tag := range exifTags {
+			if tag.Ifd != current_ifd {
+				current_ifd = tag.Ifd
+				fmt.Printf("********** %s\n", current_ifd)
+			}
+			if tag.TagName == "JPEGInterchangeFormat" {
+				// Too long to show, see 'thumbnail extract'
+				fmt.Printf("%s (0x%04x) %s[%d]: <thumbnail>\n", tag.TagName, tag.TagId, tag.Type, tag.Count)
+				continue
 			}
+			fmt.Printf("%s (0x%04x) %s[%d]: %v\n", tag.TagName, tag.TagId, tag.Type, tag.Count, tag.Value)
 		}
 		return nil
 	},
@@ -76,67 +95,69 @@ var exifExtractCmd = &cobra.Command{
 var exifEmbedCmd = &cobra.Command{
 	Use:   "embed",
 	Short: "Embed EXIF field",
+	Long: `Embed a file within a JPEG's EXIF data, either in a single tag (--exiftag), or split across
+several innocuous tags (--tags) with a small index so that 'exif extract --reassemble' can put
+it back together. IFD0 tags can be put in IFD1 instead with an '@IFD1' suffix. Either way, the whole EXIF block must fit in one 64 KiB APP1 segment.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Parse inputs
 		input_image_path, _ := cmd.Flags().GetString("image")
 		output_file_path, _ := cmd.Flags().GetString("output")
 		exif_tag, _ := cmd.Flags().GetString("exiftag")
+		chunk_tags, _ := cmd.Flags().GetStringSlice("tags")
 		secret_data_path, _ := cmd.Flags().GetString("secret")
 
 		// Read secret
-		secret_file, err := os.Open(secret_data_path)
+		secret_bytes, err := ioutil.ReadFile(secret_data_path)
 		if err != nil {
 			return err
 		}
-		defer secret_file.Close()
-		stats, err := secret_file.Stat()
-		if err != nil {
-			return err
-		}
-
-		var size int64 = stats.Size()
-		secret_bytes := make([]byte, size)
-		bufr := bufio.NewReader(secret_file)
-		bufr.Read(secret_bytes)
 
-		// Get current exif
-		rawExif, err := exif.SearchFileAndExtractExif(input_image_path)
+		// Get current exif, or start a new one
+		sl, err := lib.OpenJpegSegments(input_image_path)
 		if err != nil {
-			return err
+			return fmt.Errorf("exif embed only supports JPEG images. For PNG, use 'png embed --chunk eXIf' (%s)", err)
 		}
-		im, err := exifcommon.NewIfdMappingWithStandard()
+		ib, err := sl.ConstructExifBuilder()
 		if err != nil {
 			return err
 		}
 
-		ti := exif.NewTagIndex()
-		_, index, err := exif.Collect(im, ti, rawExif)
-		if err != nil {
-			return err
+		if len(chunk_tags) > 0 {
+			// Split across several tags, creating IFD1 in the existing byte order if needed
+			var byte_order binary.ByteOrder = exifcommon.EncodeDefaultByteOrder
+			if root_ifd, _, err := sl.Exif(); err == nil {
+				byte_order = root_ifd.ByteOrder()
+			}
+			err = lib.EmbedExifChunks(ib, byte_order, chunk_tags, secret_bytes)
+			if err != nil {
+				return err
+			}
+		} else {
+			ifdPath := "IFD0"
+			childIb, err := exif.GetOrCreateIbFromRootIb(ib, ifdPath)
+			if err != nil {
+				return err
+			}
+
+			err = childIb.SetStandardWithName(exif_tag, secret_bytes)
+			if err != nil {
+				return err
+			}
 		}
 
-		ifdPath := "IFD0"
-		ib := exif.NewIfdBuilderFromExistingChain(index.RootIfd)
-		childIb, err := exif.GetOrCreateIbFromRootIb(ib, ifdPath)
+		// Write changes
+		err = sl.SetExif(ib)
 		if err != nil {
 			return err
 		}
-
-		err = childIb.SetStandardWithName(exif_tag, secret_bytes)
+		_, exif_segment, err := sl.FindExif()
 		if err != nil {
 			return err
 		}
-
-		// Write changes
-		jmp := jpeg.NewJpegMediaParser()
-		intfc, _ := jmp.ParseFile(input_image_path)
-		sl := intfc.(*jpeg.SegmentList)
-		sl.SetExif(ib)
-		b := new(bytes.Buffer)
-		sl.Write(b)
-		ioutil.WriteFile(output_file_path, b.Bytes(), 0644)
-		return nil
-
+		if len(exif_segment.Data) > lib.JpegMaxSegmentData {
+			return fmt.Errorf("EXIF data would be %d bytes, but must fit in a %d byte APP1 segment", len(exif_segment.Data), lib.JpegMaxSegmentData)
+		}
+		return lib.WriteJpegSegments(sl, output_file_path)
 	},
 }
 
@@ -147,13 +168,16 @@ func init() {
 
 	exifEmbedCmd.Flags().StringP("image", "i", "", "(Required) Input image with EXIF data inside.")
 	exifEmbedCmd.Flags().StringP("exiftag", "e", "ProcessingSoftware", "(Optional) New EXIF tag name.")
+	exifEmbedCmd.Flags().StringSliceP("tags", "t", nil, "(Optional) Comma-separated tags to split the secret across, i.e., 'UserComment,ImageDescription,MakerNote,XPComment'. Add '@IFD1' to put an IFD0 tag in IFD1 instead, i.e., 'Artist@IFD1'.")
 	exifEmbedCmd.Flags().StringP("secret", "s", "", "(Required) Secret data filename to be embedded within EXIF tag.")
 	exifEmbedCmd.Flags().StringP("output", "o", "output.jpg", "(Default 'output.jpg') Output image path.")
 	exifEmbedCmd.MarkFlagRequired("image")
 	exifEmbedCmd.MarkFlagRequired("secret")
 
 	exifExtractCmd.Flags().StringP("image", "i", "", "(Required) Input image with EXIF data inside.")
-	exifExtractCmd.Flags().StringP("tag", "t", "", "(Optional) Exif tag name to save raw data from. Otherwise, snippers from all tags will be shown.")
+	exifExtractCmd.Flags().StringP("tag", "t", "", "(Optional) Exif tag name to save raw data from. Otherwise, all tags will be shown.")
+	exifExtractCmd.Flags().Bool("reassemble", false, "(Default false) Reassemble a payload split across several tags, and save it to the output file.")
+	exifExtractCmd.Flags().Bool("json", false, "(Default false) Show all tags as JSON.")
 	exifExtractCmd.Flags().StringP("output", "o", "output.dat", "(Default 'output.dat') Output file name for tag dump.")
 	exifExtractCmd.MarkFlagRequired("image")
 
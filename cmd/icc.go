@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"stegogo/lib"
+
+	"github.com/spf13/cobra"
+)
+
+// iccCmd represents the icc command
+var iccCmd = &cobra.Command{
+	Use:   "icc",
+	Short: "ICC profiles",
+	Long: `List, embed and extract payloads in the ICC colour profile of a JPEG (split across APP2
+segments) or PNG (iCCP chunk). ICC profiles survive many pipelines that strip EXIF.
+
+Payloads go in a private tag of the profile (--tag, 'stgo' unless given), which colour
+management ignores, so the profile stays valid and the image looks the same. Images without
+a profile are given a minimal sRGB (or grey) one, except CMYK JPEGs, which are refused. The tag
+must be a private signature, not one registered for colour management (i.e., 'desc' or 'rXYZ').`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		os.Exit(1)
+	},
+}
+
+var iccListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List ICC profile tags",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+
+		// Read profile
+		input_bytes, err := ioutil.ReadFile(input_file_path)
+		if err != nil {
+			return err
+		}
+		profile, err := lib.ReadIcc(input_bytes)
+		if err != nil {
+			return err
+		}
+		header, tags, err := lib.ParseIcc(profile)
+		if err != nil {
+			return err
+		}
+
+		// Display header and tags
+		fmt.Printf("Size: %d bytes, CMM: %q, version: %s, class: %q, colour space: %q, PCS: %q\n", header.Size, header.Cmm, header.Version, header.Class, header.ColourSpace, header.Pcs)
+		if int(header.Size) != len(profile) {
+			fmt.Printf("WARNING: Header declares %d bytes, but profile is %d.\n", header.Size, len(profile))
+		}
+		for _, tag := range tags {
+			fmt.Printf("%q type %q offset %8d %8d bytes\n", tag.Signature, tag.Type, tag.Offset, tag.Size)
+		}
+		return nil
+	},
+}
+
+var iccEmbedCmd = &cobra.Command{
+	Use:   "embed",
+	Short: "Embed data",
+	Long:  "Embed a file in a private tag of an image's ICC profile, adding a profile if needed.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		secret_file_path, _ := cmd.Flags().GetString("secret")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		tag, _ := cmd.Flags().GetString("tag")
+		if output_file_path == "" {
+			output_file_path = "output" + filepath.Ext(input_file_path)
+		}
+
+		// Read input
+		input_bytes, err := ioutil.ReadFile(input_file_path)
+		if err != nil {
+			return err
+		}
+		secret_bytes, err := ioutil.ReadFile(secret_file_path)
+		if err != nil {
+			return err
+		}
+		err = lib.ValidateIccPayloadTag(tag)
+		if err != nil {
+			return err
+		}
+		profile, err := lib.ReadIcc(input_bytes)
+		if err == lib.ErrNoIccProfile {
+			if lib.IsCmykImageData(input_bytes) {
+				return errors.New("image is a CMYK JPEG with no ICC profile, and only RGB or grey profiles can be added")
+			}
+			fmt.Println("Image has no ICC profile, adding one.")
+			profile = lib.NewIccProfile(lib.IsGrayImageData(input_bytes))
+		} else if err != nil {
+			return err
+		}
+
+		// Embed and write to file
+		profile, err = lib.SetIccTag(profile, tag, lib.NewIccDataTag(secret_bytes))
+		if err != nil {
+			return err
+		}
+		output_bytes, err := lib.WriteIcc(input_bytes, profile)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+	},
+}
+
+var iccExtractCmd = &cobra.Command{
+	Use:   "extract",
+	Short: "Extract data",
+	Long:  "Extract a file embedded with 'icc embed', or save the whole ICC profile with --profile.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		tag, _ := cmd.Flags().GetString("tag")
+		whole_profile, _ := cmd.Flags().GetBool("profile")
+
+		// Read profile
+		input_bytes, err := ioutil.ReadFile(input_file_path)
+		if err != nil {
+			return err
+		}
+		profile, err := lib.ReadIcc(input_bytes)
+		if err != nil {
+			return err
+		}
+		if whole_profile {
+			return ioutil.WriteFile(output_file_path, profile, 0644)
+		}
+
+		// Write to file
+		tag_data, err := lib.GetIccTag(profile, tag)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(output_file_path, lib.ReadIccDataTag(tag_data), 0644)
+	},
+}
+
+func init() {
+	// Add commands
+	rootCmd.AddCommand(iccCmd)
+	iccCmd.AddCommand(iccListCmd)
+	iccCmd.AddCommand(iccEmbedCmd)
+	iccCmd.AddCommand(iccExtractCmd)
+
+	// Add flags
+	iccCmd.PersistentFlags().StringP("tag", "t", lib.IccPayloadTag, "(Default 'stgo') Four character signature of the tag holding the payload.")
+
+	iccListCmd.Flags().StringP("input", "i", "", "(Required) Input JPEG or PNG image.")
+	iccListCmd.MarkFlagRequired("input")
+
+	iccEmbedCmd.Flags().StringP("input", "i", "", "(Required) Input JPEG or PNG image.")
+	iccEmbedCmd.Flags().StringP("secret", "s", "", "(Required) A file to be embedded.")
+	iccEmbedCmd.Flags().StringP("output", "o", "", "(Default 'output' with the input's extension) Output image path.")
+	iccEmbedCmd.MarkFlagRequired("input")
+	iccEmbedCmd.MarkFlagRequired("secret")
+
+	iccExtractCmd.Flags().StringP("input", "i", "", "(Required) Input JPEG or PNG image.")
+	iccExtractCmd.Flags().StringP("output", "o", "extracted.dat", "(Default 'extracted.dat') Output extracted data file.")
+	iccExtractCmd.Flags().Bool("profile", false, "(Default false) Save the whole ICC profile instead of a payload.")
+	iccExtractCmd.MarkFlagRequired("input")
+}
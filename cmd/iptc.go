@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"stegogo/lib"
+
+	"github.com/spf13/cobra"
+)
+
+// iptcCmd represents the iptc command
+var iptcCmd = &cobra.Command{
+	Use:   "iptc",
+	Short: "IPTC and Photoshop IRB metadata",
+	Long: `List, embed and extract payloads in the Photoshop Image Resource Blocks (IRB) of a JPEG's APP13
+segment, and in the IPTC-IIM record stored in one of those resources.
+
+By default payloads go in an IPTC dataset (--dataset, '2:202' ObjectData Preview Data unless
+given). With --irb they go in an image resource of their own instead (--resource, the first
+plug-in resource 0x0fa0 unless given). Other datasets and resources are kept.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		os.Exit(1)
+	},
+}
+
+var iptcListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List image resources and IPTC datasets",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+
+		// Read resources
+		sl, err := lib.OpenJpegSegments(input_file_path)
+		if err != nil {
+			return err
+		}
+		records, err := lib.ReadJpegIrb(sl)
+		if err != nil {
+			return err
+		}
+
+		// Display resources, and the datasets of any IPTC record
+		fmt.Println("********** Image Resources")
+		for _, record := range records {
+			fmt.Printf("0x%04x %-26s %q %8d bytes\n", record.ImageResourceId, lib.IrbResourceName(record.ImageResourceId), record.Name, len(record.Data))
+		}
+		iptc_bytes, err := lib.GetIrbResource(records, lib.IrbIptcResource)
+		if err != nil {
+			return nil
+		}
+		datasets, err := lib.ParseIptc(iptc_bytes)
+		if err != nil {
+			return fmt.Errorf("IPTC record can't be parsed: %s", err)
+		}
+		fmt.Println("********** IPTC")
+		for _, dataset := range datasets {
+			preview := dataset.Data
+			if len(preview) > 40 {
+				preview = preview[:40]
+			}
+			fmt.Printf("%d:%-3d %-26s %8d bytes  %q\n", dataset.Record, dataset.Dataset, lib.IptcDatasetName(dataset.Record, dataset.Dataset), len(dataset.Data), preview)
+		}
+		return nil
+	},
+}
+
+var iptcEmbedCmd = &cobra.Command{
+	Use:   "embed",
+	Short: "Embed data",
+	Long:  "Embed a file in an IPTC dataset, or with --irb in a Photoshop image resource, of a JPEG.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		secret_file_path, _ := cmd.Flags().GetString("secret")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		dataset_name, _ := cmd.Flags().GetString("dataset")
+		irb, _ := cmd.Flags().GetBool("irb")
+		resource, _ := cmd.Flags().GetUint16("resource")
+
+		// Read input
+		record, dataset, err := lib.ParseIptcDataset(dataset_name)
+		if err != nil {
+			return err
+		}
+		secret_bytes, err := ioutil.ReadFile(secret_file_path)
+		if err != nil {
+			return err
+		}
+		sl, err := lib.OpenJpegSegments(input_file_path)
+		if err != nil {
+			return err
+		}
+		records, err := lib.ReadJpegIrb(sl)
+		if err != nil && err != lib.ErrNoIrb {
+			// Rewriting would lose the resources that didn't parse
+			return fmt.Errorf("existing Photoshop image resources can't be parsed: %s", err)
+		}
+
+		// Embed
+		if irb {
+			if resource == lib.IrbIptcResource || resource == lib.IrbCaptionDigestResource {
+				return fmt.Errorf("resource 0x%04x holds IPTC data. Embed without --irb to use IPTC datasets", resource)
+			}
+			records = lib.SetIrbResource(records, resource, secret_bytes)
+		} else {
+			var datasets []lib.IptcDataset
+			if iptc_bytes, err := lib.GetIrbResource(records, lib.IrbIptcResource); err == nil {
+				datasets, err = lib.ParseIptc(iptc_bytes)
+				if err != nil {
+					return fmt.Errorf("existing IPTC record can't be parsed: %s", err)
+				}
+			}
+			datasets = lib.SetIptcDataset(datasets, record, dataset, secret_bytes)
+			records = lib.SetJpegIptc(records, datasets)
+		}
+
+		// Write to file
+		return lib.WriteJpegSegments(lib.WriteJpegIrb(sl, records), output_file_path)
+	},
+}
+
+var iptcExtractCmd = &cobra.Command{
+	Use:   "extract",
+	Short: "Extract data",
+	Long:  "Extract an IPTC dataset, or with --irb a Photoshop image resource, of a JPEG to a file.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		dataset_name, _ := cmd.Flags().GetString("dataset")
+		irb, _ := cmd.Flags().GetBool("irb")
+		resource, _ := cmd.Flags().GetUint16("resource")
+
+		// Read resources
+		record, dataset, err := lib.ParseIptcDataset(dataset_name)
+		if err != nil {
+			return err
+		}
+		sl, err := lib.OpenJpegSegments(input_file_path)
+		if err != nil {
+			return err
+		}
+		records, err := lib.ReadJpegIrb(sl)
+		if err != nil {
+			return err
+		}
+		if irb {
+			output_bytes, err := lib.GetIrbResource(records, resource)
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+		}
+
+		// Find dataset
+		iptc_bytes, err := lib.GetIrbResource(records, lib.IrbIptcResource)
+		if err != nil {
+			return err
+		}
+		datasets, err := lib.ParseIptc(iptc_bytes)
+		if err != nil {
+			return err
+		}
+		for _, existing := range datasets {
+			if existing.Record == record && existing.Dataset == dataset {
+				return ioutil.WriteFile(output_file_path, existing.Data, 0644)
+			}
+		}
+		return fmt.Errorf("IPTC dataset %s not found", dataset_name)
+	},
+}
+
+func init() {
+	// Add commands
+	rootCmd.AddCommand(iptcCmd)
+	iptcCmd.AddCommand(iptcListCmd)
+	iptcCmd.AddCommand(iptcEmbedCmd)
+	iptcCmd.AddCommand(iptcExtractCmd)
+
+	// Add flags
+	iptcCmd.PersistentFlags().String("dataset", "2:202", "(Default '2:202') IPTC dataset to use, as 'record:dataset'.")
+	iptcCmd.PersistentFlags().Bool("irb", false, "(Default false) Use a Photoshop image resource instead of an IPTC dataset.")
+	iptcCmd.PersistentFlags().Uint16("resource", lib.IrbPayloadResource, "(Default 0x0fa0) Image resource ID to use with --irb.")
+
+	iptcListCmd.Flags().StringP("input", "i", "", "(Required) Input JPEG file.")
+	iptcListCmd.MarkFlagRequired("input")
+
+	iptcEmbedCmd.Flags().StringP("input", "i", "", "(Required) Input JPEG file.")
+	iptcEmbedCmd.Flags().StringP("secret", "s", "", "(Required) A file to be embedded.")
+	iptcEmbedCmd.Flags().StringP("output", "o", "output.jpg", "(Default 'output.jpg') Output image path.")
+	iptcEmbedCmd.MarkFlagRequired("input")
+	iptcEmbedCmd.MarkFlagRequired("secret")
+
+	iptcExtractCmd.Flags().StringP("input", "i", "", "(Required) Input JPEG file.")
+	iptcExtractCmd.Flags().StringP("output", "o", "extracted.dat", "(Default 'extracted.dat') Output extracted data file.")
+	iptcExtractCmd.MarkFlagRequired("input")
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"stegogo/lib"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// jpegCmd represents the jpeg command
+var jpegCmd = &cobra.Command{
+	Use:   "jpeg",
+	Short: "JPEG DCT coefficient steganography",
+	Long: `Embed and extract data in the quantised DCT coefficients of a JPEG, so the image is never
+decoded to pixels and recompressed. Outputs are written as baseline JPEGs with the cover's
+quantisation tables and metadata segments, and Huffman tables optimised for the new
+coefficients. Progressive covers are read, but written as baseline.
+
+Methods (--method):
+  jsteg  Replaces the LSB of each AC coefficient that isn't 0 or 1, in file order. Simple, with
+         high capacity, but easily found by a chi-square attack.
+  f5     Decreases the magnitude of nonzero AC coefficients, visited in an order shuffled by
+         --key, with matrix encoding so fewer coefficients change per bit when the secret is
+         small compared to the image. Stores the secret's length, so only it is extracted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		os.Exit(1)
+	},
+}
+
+var jpegEmbedCmd = &cobra.Command{
+	Use:   "embed",
+	Short: "Embed data",
+	Long:  "Embed a file in the DCT coefficients of a JPEG.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		cover_file_path, _ := cmd.Flags().GetString("cover")
+		secret_file_path, _ := cmd.Flags().GetString("secret")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		method, _ := cmd.Flags().GetString("method")
+		key, _ := cmd.Flags().GetString("key")
+
+		// Open cover
+		coefficients, err := lib.OpenJpegCoefficients(cover_file_path)
+		if err != nil {
+			return err
+		}
+		if coefficients.Progressive {
+			fmt.Println("Cover is a progressive JPEG; the output will be baseline.")
+		}
+
+		// Embed
+		switch method {
+		case "jsteg":
+			secret_bits, err := lib.FilepathToBitstream(secret_file_path)
+			if err != nil {
+				return err
+			}
+			lib.EmbedJsteg(coefficients, secret_bits)
+		case "f5":
+			secret_bytes, err := ioutil.ReadFile(secret_file_path)
+			if err != nil {
+				return err
+			}
+			k, err := lib.EmbedF5(coefficients, secret_bytes, key)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Embedded %d bytes with (1, %d, %d) matrix encoding.\n", len(secret_bytes), (1<<uint(k))-1, k)
+		default:
+			return fmt.Errorf("invalid method '%s'. Must be one of: %s", method, strings.Join(lib.JpegMethods, ", "))
+		}
+
+		// Write image to file
+		output_bytes, err := lib.WriteJpegCoefficients(coefficients)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+	},
+}
+
+var jpegExtractCmd = &cobra.Command{
+	Use:   "extract",
+	Short: "Extract data",
+	Long:  "Extract data embedded with 'jpeg embed'. JSteg extracts all of the image's capacity; F5 extracts the embedded length.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		method, _ := cmd.Flags().GetString("method")
+		key, _ := cmd.Flags().GetString("key")
+
+		// Open input
+		coefficients, err := lib.OpenJpegCoefficients(input_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Extract
+		var output_bytes []byte
+		switch method {
+		case "jsteg":
+			output_bytes = lib.BitstreamToBytes(lib.ExtractJsteg(coefficients))
+		case "f5":
+			output_bytes, err = lib.ExtractF5(coefficients, key)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("invalid method '%s'. Must be one of: %s", method, strings.Join(lib.JpegMethods, ", "))
+		}
+
+		// Write to file
+		return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+	},
+}
+
+func init() {
+	// Add commands
+	rootCmd.AddCommand(jpegCmd)
+	jpegCmd.AddCommand(jpegEmbedCmd)
+	jpegCmd.AddCommand(jpegExtractCmd)
+
+	// Add flags
+	jpegCmd.PersistentFlags().String("method", "f5", "(Default 'f5') Method to use, 'jsteg' or 'f5'.")
+	jpegCmd.PersistentFlags().String("key", "", "(Optional) Passphrase that shuffles the coefficient order for F5.")
+
+	jpegEmbedCmd.Flags().StringP("cover", "c", "", "(Required) A JPEG cover image.")
+	jpegEmbedCmd.Flags().StringP("secret", "s", "", "(Required) A file to be embedded in the image.")
+	jpegEmbedCmd.Flags().StringP("output", "o", "output.jpg", "(Default 'output.jpg') Output image path.")
+	jpegEmbedCmd.MarkFlagRequired("cover")
+	jpegEmbedCmd.MarkFlagRequired("secret")
+
+	jpegExtractCmd.Flags().StringP("input", "i", "", "(Required) Input JPEG with embedded data.")
+	jpegExtractCmd.Flags().StringP("output", "o", "extracted.dat", "(Default 'extracted.dat') Output extracted data file.")
+	jpegExtractCmd.MarkFlagRequired("input")
+}
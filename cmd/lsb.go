@@ -2,9 +2,7 @@ package cmd
 
 import (
 	"errors"
-	"image/png"
 	"io/ioutil"
-	"log"
 	"os"
 
 	"stegogo/lib"
@@ -42,6 +40,7 @@ var lsbEmbedCmd = &cobra.Command{
 		secret_file_path, _ := cmd.Flags().GetString("secret")
 		cover_file_path, _ := cmd.Flags().GetString("cover")
 		output_file_path, _ := cmd.Flags().GetString("output")
+		output_format, _ := cmd.Flags().GetString("format")
 
 		// Parse secret
 		secret_bits, err := lib.FilepathToBitstream(secret_file_path)
@@ -54,6 +53,10 @@ var lsbEmbedCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		err = lib.CheckCoverDepth(img)
+		if err != nil {
+			return err
+		}
 
 		// Check order
 		order := "row"
@@ -68,13 +71,7 @@ var lsbEmbedCmd = &cobra.Command{
 		}
 
 		// Write image to file
-		outFile, err := os.Create(output_file_path)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer outFile.Close()
-		png.Encode(outFile, edited_img)
-		return nil
+		return lib.SaveImageLike(edited_img, output_file_path, output_format, cover_file_path)
 	},
 }
 
@@ -125,6 +122,7 @@ func init() {
 	lsbEmbedCmd.Flags().StringP("secret", "s", "", "(Required) A file to be embedded in the image.")
 	lsbEmbedCmd.Flags().StringP("cover", "c", "", "(Required) A cover image to have data embedded within.")
 	lsbEmbedCmd.Flags().StringP("output", "o", "output.png", "(Default 'output.png') Output image path.")
+	lsbEmbedCmd.Flags().String("format", "", "(Optional) Output image format: png, bmp, pgm, ppm, pnm, pam, pgm-plain or ppm-plain. Otherwise, chosen by the output file extension.")
 	lsbEmbedCmd.MarkFlagRequired("secret")
 	lsbEmbedCmd.MarkFlagRequired("cover")
 
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"stegogo/lib"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// paletteCmd represents the palette command
+var paletteCmd = &cobra.Command{
+	Use:   "palette",
+	Short: "Palette (indexed-colour) steganography",
+	Long: `Embed and extract data in GIFs and paletted (PNG8) PNGs, whose pixels are indices into a
+palette of colours. Outputs stay paletted, in the cover's format. In animated GIFs the payload
+continues from frame to frame, and delays, disposal methods and the loop count are kept.
+
+Methods (--method):
+  ezstego      The palette is sorted by luminance, and each pixel carries one bit as the
+               parity of its colour's position, changing to the neighbouring, very similar,
+               colour when needed. One bit per pixel.
+  permutation  The data picks the order of the palette's colours. Pixels are remapped, so the
+               image looks exactly the same, but capacity is only log2(n!) bits for n colours,
+               per palette: the GIF's global colour table, then each frame's local one.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		os.Exit(1)
+	},
+}
+
+var paletteEmbedCmd = &cobra.Command{
+	Use:   "embed",
+	Short: "Embed data",
+	Long:  "Embed a file in the frames of a GIF or a paletted PNG via palette steganography.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		cover_file_path, _ := cmd.Flags().GetString("cover")
+		secret_file_path, _ := cmd.Flags().GetString("secret")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		method, _ := cmd.Flags().GetString("method")
+		if output_file_path == "" {
+			output_file_path = "output" + filepath.Ext(cover_file_path)
+		}
+
+		// Open cover
+		frames, cover_bytes, err := lib.OpenPalettedImage(cover_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Embed
+		switch method {
+		case "ezstego":
+			secret_bits, err := lib.FilepathToBitstream(secret_file_path)
+			if err != nil {
+				return err
+			}
+			frames = lib.EmbedEzStego(frames, secret_bits)
+		case "permutation":
+			secret_bytes, err := ioutil.ReadFile(secret_file_path)
+			if err != nil {
+				return err
+			}
+			frames = lib.EmbedPalettePermutation(frames, secret_bytes)
+		default:
+			return fmt.Errorf("invalid method '%s'. Must be one of: %s", method, strings.Join(lib.PaletteMethods, ", "))
+		}
+
+		// Write image to file
+		return lib.SavePalettedLike(frames, output_file_path, cover_bytes)
+	},
+}
+
+var paletteExtractCmd = &cobra.Command{
+	Use:   "extract",
+	Short: "Extract data",
+	Long:  "Extract data embedded with 'palette embed', walking a GIF's frames in order. All of the image's capacity is extracted.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		method, _ := cmd.Flags().GetString("method")
+
+		// Open input
+		frames, _, err := lib.OpenPalettedImage(input_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Extract
+		var output_bytes []byte
+		switch method {
+		case "ezstego":
+			output_bytes = lib.BitstreamToBytes(lib.ExtractEzStego(frames))
+		case "permutation":
+			output_bytes = lib.ExtractPalettePermutation(frames)
+		default:
+			return fmt.Errorf("invalid method '%s'. Must be one of: %s", method, strings.Join(lib.PaletteMethods, ", "))
+		}
+
+		// Write to file
+		return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+	},
+}
+
+func init() {
+	// Add commands
+	rootCmd.AddCommand(paletteCmd)
+	paletteCmd.AddCommand(paletteEmbedCmd)
+	paletteCmd.AddCommand(paletteExtractCmd)
+
+	// Add flags
+	paletteCmd.PersistentFlags().String("method", "ezstego", "(Default 'ezstego') Method to use, 'ezstego' or 'permutation'.")
+
+	paletteEmbedCmd.Flags().StringP("cover", "c", "", "(Required) A GIF or paletted PNG cover image.")
+	paletteEmbedCmd.Flags().StringP("secret", "s", "", "(Required) A file to be embedded in the image.")
+	paletteEmbedCmd.Flags().StringP("output", "o", "", "(Default 'output' with the cover's extension) Output image path.")
+	paletteEmbedCmd.MarkFlagRequired("cover")
+	paletteEmbedCmd.MarkFlagRequired("secret")
+
+	paletteExtractCmd.Flags().StringP("input", "i", "", "(Required) Input GIF or paletted PNG with embedded data.")
+	paletteExtractCmd.Flags().StringP("output", "o", "extracted.dat", "(Default 'extracted.dat') Output extracted data file.")
+	paletteExtractCmd.MarkFlagRequired("input")
+}
@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"stegogo/lib"
+
+	"github.com/spf13/cobra"
+)
+
+// pngCmd represents the png command
+var pngCmd = &cobra.Command{
+	Use:   "png",
+	Short: "PNG chunk manipulation",
+	Long: `List, embed and extract payloads in PNG metadata chunks.
+
+Payloads can be stored in text chunks (tEXt, zTXt or iTXt) under a keyword, in the MakerNote
+tag of an eXIf chunk (added to any EXIF data already there), or in a private ancillary chunk with
+a custom type (i.e., 'stGo'). All existing chunks, and any data after IEND, are kept, and CRCs
+are recomputed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		os.Exit(1)
+	},
+}
+
+var pngListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List chunks",
+	Long:  "List every chunk in a PNG, with the keyword and a preview of any text chunks.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+
+		// Read chunks
+		chunks, err := lib.OpenPngChunks(input_file_path)
+		if err != nil {
+			return err
+		}
+
+		for index, chunk := range chunks {
+			fmt.Printf("%3d %s %8d bytes", index, chunk.Type, len(chunk.Data))
+			if lib.IsPngTextChunk(chunk.Type) {
+				keyword, text, err := lib.ReadPngTextChunk(chunk)
+				if err != nil {
+					fmt.Printf("  (%s)", err)
+				} else {
+					if len(text) > 60 {
+						text = text[:60]
+					}
+					fmt.Printf("  %s: %q", keyword, text)
+				}
+			}
+			fmt.Println()
+		}
+		return nil
+	},
+}
+
+var pngEmbedCmd = &cobra.Command{
+	Use:   "embed",
+	Short: "Embed data",
+	Long:  "Embed a file within a new PNG chunk.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		secret_file_path, _ := cmd.Flags().GetString("secret")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		chunk_type, _ := cmd.Flags().GetString("chunk")
+		keyword, _ := cmd.Flags().GetString("keyword")
+
+		// Read input, keeping anything after IEND
+		input_bytes, err := ioutil.ReadFile(input_file_path)
+		if err != nil {
+			return err
+		}
+		chunks, err := lib.ParsePngChunks(input_bytes)
+		if err != nil {
+			return err
+		}
+		image_end, err := lib.FindImageEnd(input_bytes)
+		if err != nil {
+			return err
+		}
+		secret_bytes, err := ioutil.ReadFile(secret_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Build new chunk
+		var chunk lib.PngChunk
+		if lib.IsPngTextChunk(chunk_type) {
+			chunk, err = lib.NewPngTextChunk(chunk_type, keyword, secret_bytes)
+			if err != nil {
+				return err
+			}
+		} else if chunk_type == "eXIf" {
+			chunk, err = lib.NewPngExifChunk(chunks, secret_bytes)
+			if err != nil {
+				return err
+			}
+		} else {
+			err = lib.ValidatePngChunkType(chunk_type)
+			if err != nil {
+				return err
+			}
+			chunk = lib.PngChunk{Type: chunk_type, Data: secret_bytes}
+		}
+
+		// Write to file
+		chunks = lib.InsertPngChunk(chunks, chunk)
+		output_bytes := append(lib.EncodePngChunks(chunks), input_bytes[image_end:]...)
+		return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+	},
+}
+
+var pngExtractCmd = &cobra.Command{
+	Use:   "extract",
+	Short: "Extract data",
+	Long:  "Extract the contents of a PNG chunk. For text chunks, the first chunk with the given keyword is used.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		chunk_type, _ := cmd.Flags().GetString("chunk")
+		keyword, _ := cmd.Flags().GetString("keyword")
+
+		// Read chunks
+		chunks, err := lib.OpenPngChunks(input_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Find matching chunk
+		for _, chunk := range chunks {
+			if chunk.Type != chunk_type {
+				continue
+			}
+			output_bytes := chunk.Data
+			if chunk_type == "eXIf" {
+				payload, err := lib.ExtractExifChunks(chunk.Data)
+				if err != nil {
+					return err
+				}
+				output_bytes = payload
+			}
+			if lib.IsPngTextChunk(chunk_type) {
+				chunk_keyword, text, err := lib.ReadPngTextChunk(chunk)
+				if err != nil {
+					return err
+				}
+				if chunk_keyword != keyword {
+					continue
+				}
+				output_bytes = text
+			}
+			return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+		}
+		return errors.New("no matching chunk found")
+	},
+}
+
+func init() {
+	// Add commands
+	rootCmd.AddCommand(pngCmd)
+	pngCmd.AddCommand(pngListCmd)
+	pngCmd.AddCommand(pngEmbedCmd)
+	pngCmd.AddCommand(pngExtractCmd)
+
+	// Add flags
+	pngListCmd.Flags().StringP("input", "i", "", "(Required) Input PNG file.")
+	pngListCmd.MarkFlagRequired("input")
+
+	pngEmbedCmd.Flags().StringP("input", "i", "", "(Required) Input PNG file.")
+	pngEmbedCmd.Flags().StringP("secret", "s", "", "(Required) A file to be embedded in the image.")
+	pngEmbedCmd.Flags().StringP("output", "o", "output.png", "(Default 'output.png') Output image path.")
+	pngEmbedCmd.Flags().StringP("chunk", "c", "tEXt", "(Default 'tEXt') Chunk type to embed within. Either 'tEXt', 'zTXt', 'iTXt', 'eXIf' or a custom ancillary type (i.e., 'stGo').")
+	pngEmbedCmd.Flags().StringP("keyword", "k", "Comment", "(Default 'Comment') Keyword for text chunks.")
+	pngEmbedCmd.MarkFlagRequired("secret")
+	pngEmbedCmd.MarkFlagRequired("input")
+
+	pngExtractCmd.Flags().StringP("input", "i", "", "(Required) Input PNG file with embedded data inside.")
+	pngExtractCmd.Flags().StringP("output", "o", "extracted.dat", "(Default 'extracted.dat') Output extracted data file.")
+	pngExtractCmd.Flags().StringP("chunk", "c", "tEXt", "(Default 'tEXt') Chunk type to extract.")
+	pngExtractCmd.Flags().StringP("keyword", "k", "Comment", "(Default 'Comment') Keyword to look for in text chunks.")
+	pngExtractCmd.MarkFlagRequired("input")
+}
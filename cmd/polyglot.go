@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"stegogo/lib"
+
+	"github.com/spf13/cobra"
+)
+
+// polyglotCmd represents the polyglot command
+var polyglotCmd = &cobra.Command{
+	Use:   "polyglot",
+	Short: "Image/ZIP polyglots",
+	Long: `Create and detect files that are both a valid image (PNG, JPEG, GIF or BMP) and a valid ZIP
+archive. Image decoders read from the start of the file and stop at the end of the image,
+while ZIP tools find the archive from its central directory at the end of the file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		os.Exit(1)
+	},
+}
+
+var polyglotCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Combine an image and a ZIP",
+	Long:  "Put a ZIP archive after the end of an image, moving the archive's offsets so ZIP tools open it as it is.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		cover_file_path, _ := cmd.Flags().GetString("cover")
+		zip_file_path, _ := cmd.Flags().GetString("zip")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		if output_file_path == "" {
+			output_file_path = "output" + filepath.Ext(cover_file_path)
+		}
+
+		// Read inputs
+		cover_bytes, err := ioutil.ReadFile(cover_file_path)
+		if err != nil {
+			return err
+		}
+		zip_bytes, err := ioutil.ReadFile(zip_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Combine and write to file
+		output_bytes, err := lib.MakeZipPolyglot(cover_bytes, zip_bytes)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+	},
+}
+
+var polyglotDetectCmd = &cobra.Command{
+	Use:   "detect",
+	Short: "Detect a ZIP in an image",
+	Long:  "Report whether an image also parses as a ZIP archive, and list the archive's entries.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+
+		// Read input
+		input_bytes, err := ioutil.ReadFile(input_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Report
+		info, err := lib.DetectZipPolyglot(input_bytes)
+		if err != nil {
+			return err
+		}
+		if info == nil {
+			fmt.Println("File does not parse as a ZIP archive.")
+			return nil
+		}
+		fmt.Printf("File is a %s image ending at offset %d, and also a ZIP archive.\n", info.ImageFormat, info.ImageEnd)
+		location := "after the image"
+		if info.ArchiveStart < info.ImageEnd {
+			location = "inside the image"
+		}
+		fmt.Printf("Archive starts at offset %d (%s), central directory at offset %d.\n", info.ArchiveStart, location, info.CentralDirectory)
+		if info.Unadjusted {
+			fmt.Println("Archive offsets were not moved to match its position, so some ZIP tools will warn.")
+		}
+		fmt.Printf("%d entries:\n", len(info.Entries))
+		for _, entry := range info.Entries {
+			fmt.Printf("  %10d  %10d  %s  %s\n", entry.UncompressedSize, entry.CompressedSize, entry.Modified.Format("2006-01-02 15:04"), entry.Name)
+		}
+		return nil
+	},
+}
+
+func init() {
+	// Add commands
+	rootCmd.AddCommand(polyglotCmd)
+	polyglotCmd.AddCommand(polyglotCreateCmd)
+	polyglotCmd.AddCommand(polyglotDetectCmd)
+
+	// Add flags
+	polyglotCreateCmd.Flags().StringP("cover", "c", "", "(Required) A PNG, JPEG, GIF or BMP cover image.")
+	polyglotCreateCmd.Flags().StringP("zip", "z", "", "(Required) A ZIP archive to combine with the image.")
+	polyglotCreateCmd.Flags().StringP("output", "o", "", "(Default 'output' with the cover's extension) Output file path.")
+	polyglotCreateCmd.MarkFlagRequired("cover")
+	polyglotCreateCmd.MarkFlagRequired("zip")
+
+	polyglotDetectCmd.Flags().StringP("input", "i", "", "(Required) Input image to check.")
+	polyglotDetectCmd.MarkFlagRequired("input")
+}
@@ -1,12 +1,12 @@
 package cmd
 
 import (
-	"image/png"
+	"errors"
+	"fmt"
+	"image"
 	"io/ioutil"
-	"log"
 	"os"
 	"stegogo/lib"
-	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -17,7 +17,20 @@ var pvdCmd = &cobra.Command{
 	Short: "Pixel Value Differencing",
 	Long: `Pixel Value Differencing (PVD) steganography is a technique proposed by Da-Chun Wu and Wen-Hsiang Tsai in a 2003 academic paper.
 Secret data is embedded by manipulating the difference between pairs of pixels.
-Optionally, add range widths to the end (i.e., 2 2 4 8 16 32). Otherwise, the default of '8 8 16 32 64 128' is used.`,
+Optionally, add range widths to the end (i.e., 2 2 4 4 4 8 8 16 16 32 32 64 64), or the name of a preset. Otherwise, the default of '8 8 16 32 64 128' is used.
+Range widths must each be a power of two, and add up to exactly 256.
+
+Presets:
+  wu-tsai: 8 8 16 32 64 128
+  narrow:  2 2 4 4 4 8 8 16 16 32 32 64 64
+  wide:    16 16 32 64 128
+
+By default, pixels are differenced in adjacent pairs. Block-based schemes can be chosen with --scheme:
+  tri:  2x2 blocks, with horizontal, vertical and diagonal differences from the top-left pixel.
+  four: 3x3 blocks, with differences between the centre pixel and its four neighbours.
+
+With --hybrid, the Wu et al. PVD and LSB replacement method is used instead: pairs with a difference
+at or below --threshold have --lsb-bits replaced in each pixel, and all other pairs are embedded with PVD.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		cmd.Help()
 		os.Exit(1)
@@ -33,9 +46,16 @@ var pvdEmbedCmd = &cobra.Command{
 		direction, _ := cmd.Flags().GetString("direction")
 		zigzag, _ := cmd.Flags().GetBool("zigzag")
 		plane, _ := cmd.Flags().GetString("plane")
+		scheme, _ := cmd.Flags().GetString("scheme")
+		hybrid, _ := cmd.Flags().GetBool("hybrid")
+		lsb_threshold, _ := cmd.Flags().GetInt("threshold")
+		lsb_bits, _ := cmd.Flags().GetInt("lsb-bits")
+		key, _ := cmd.Flags().GetString("key")
+		orient, _ := cmd.Flags().GetBool("orient")
 		secret_file_path, _ := cmd.Flags().GetString("secret")
 		cover_file_path, _ := cmd.Flags().GetString("cover")
 		output_file_path, _ := cmd.Flags().GetString("output")
+		output_format, _ := cmd.Flags().GetString("format")
 
 		// Parse secret
 		secret_bitstream, err := lib.FilepathToBitstream(secret_file_path)
@@ -57,30 +77,41 @@ var pvdEmbedCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		err = lib.CheckCoverDepth(img)
+		if err != nil {
+			return err
+		}
 
 		// Create range table
-		if len(range_widths) == 0 {
-			range_widths = strings.Split("8 8 16 32 64 128", " ")
+		range_table, err := pvdRangeTable(range_widths, key)
+		if err != nil {
+			return err
 		}
-		range_table, err := lib.CreateRangeTableArray(range_widths)
+		orient_key, err := pvdOrientKey(key, orient, scheme)
 		if err != nil {
 			return err
 		}
 
+		lsb_threshold = pvdLsbThreshold(cmd, range_table, key, lsb_threshold, lsb_bits)
+
 		// Run embed function
-		new_img, err := lib.EmbedPvd(img, range_table, secret_bitstring, direction, zigzag, plane)
+		var new_img image.Image
+		if hybrid {
+			if scheme != "pair" {
+				return errors.New("hybrid mode can only be used with the 'pair' scheme")
+			}
+			new_img, err = lib.EmbedPvdLsb(img, range_table, secret_bitstring, lsb_threshold, lsb_bits, direction, zigzag, plane)
+		} else if scheme == "pair" {
+			new_img, err = lib.EmbedPvd(img, range_table, secret_bitstring, direction, zigzag, plane)
+		} else {
+			new_img, err = lib.EmbedPvdBlock(img, range_table, secret_bitstring, scheme, direction, zigzag, plane, orient_key)
+		}
 		if err != nil {
 			return err
 		}
 
 		// Write image to file
-		outFile, err := os.Create(output_file_path)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer outFile.Close()
-		png.Encode(outFile, new_img)
-		return nil
+		return lib.SaveImageLike(new_img, output_file_path, output_format, cover_file_path)
 	},
 }
 
@@ -93,6 +124,13 @@ var pvdExtractCmd = &cobra.Command{
 		direction, _ := cmd.Flags().GetString("direction")
 		zigzag, _ := cmd.Flags().GetBool("zigzag")
 		plane, _ := cmd.Flags().GetString("plane")
+		scheme, _ := cmd.Flags().GetString("scheme")
+		hybrid, _ := cmd.Flags().GetBool("hybrid")
+		lsb_threshold, _ := cmd.Flags().GetInt("threshold")
+		lsb_bits, _ := cmd.Flags().GetInt("lsb-bits")
+		key, _ := cmd.Flags().GetString("key")
+		orient, _ := cmd.Flags().GetBool("orient")
+		auto, _ := cmd.Flags().GetBool("auto")
 		input_file_path, _ := cmd.Flags().GetString("input")
 		output_file_path, _ := cmd.Flags().GetString("output")
 
@@ -102,19 +140,75 @@ var pvdExtractCmd = &cobra.Command{
 			return err
 		}
 
-		// Create range table
-		if len(range_widths) == 0 {
-			range_widths = strings.Split("8 8 16 32 64 128", " ")
-		}
-		range_table, err := lib.CreateRangeTableArray(range_widths)
+		orient_key, err := pvdOrientKey(key, orient, scheme)
 		if err != nil {
 			return err
 		}
 
 		// Run extract function
-		output_bytes, err := lib.ExtractPvd(img, range_table, direction, zigzag, plane)
-		if err != nil {
-			return err
+		extract := func(range_table [][]int, direction string, zigzag bool) ([]byte, error) {
+			if hybrid {
+				if scheme != "pair" {
+					return nil, errors.New("hybrid mode can only be used with the 'pair' scheme")
+				}
+				return lib.ExtractPvdLsb(img, range_table, pvdLsbThreshold(cmd, range_table, key, lsb_threshold, lsb_bits), lsb_bits, direction, zigzag, plane)
+			} else if scheme == "pair" {
+				return lib.ExtractPvd(img, range_table, direction, zigzag, plane)
+			}
+			return lib.ExtractPvdBlock(img, range_table, scheme, direction, zigzag, plane, orient_key)
+		}
+
+		var output_bytes []byte
+		if auto {
+			if key != "" {
+				return errors.New("--auto can't be used with --key")
+			}
+			// Try any range widths given, then every preset, in each direction,
+			// keeping the most plausible result
+			candidate_names := lib.PvdRangePresetNames
+			candidate_widths := lib.PvdRangePresets
+			if len(range_widths) > 0 {
+				custom_widths := lib.RangeWidthsFromArgs(range_widths)
+				if _, err := lib.CreateRangeTableArray(custom_widths); err != nil {
+					return err
+				}
+				candidate_names = append([]string{"given"}, lib.PvdRangePresetNames...)
+				candidate_widths = map[string][]string{"given": custom_widths}
+				for name, widths := range lib.PvdRangePresets {
+					candidate_widths[name] = widths
+				}
+			}
+			best_score := -1.0
+			for _, preset := range candidate_names {
+				range_table, err := lib.CreateRangeTableArray(candidate_widths[preset])
+				if err != nil {
+					return err
+				}
+				for _, try_direction := range []string{"row", "column"} {
+					for _, try_zigzag := range []bool{false, true} {
+						extracted_bytes, err := extract(range_table, try_direction, try_zigzag)
+						if err != nil {
+							return err
+						}
+						score := lib.PlausibilityScore(extracted_bytes)
+						fmt.Printf("%-8s %-7s zigzag=%-5t score=%.3f %s\n", preset, try_direction, try_zigzag, score, lib.DetectFileType(extracted_bytes))
+						if score > best_score {
+							best_score = score
+							output_bytes = extracted_bytes
+						}
+					}
+				}
+			}
+		} else {
+			// Create range table
+			range_table, err := pvdRangeTable(range_widths, key)
+			if err != nil {
+				return err
+			}
+			output_bytes, err = extract(range_table, direction, zigzag)
+			if err != nil {
+				return err
+			}
 		}
 
 		// Write to file
@@ -123,6 +217,40 @@ var pvdExtractCmd = &cobra.Command{
 	},
 }
 
+func pvdRangeTable(range_widths []string, key string) ([][]int, error) {
+	// Derive the range table from the key if given, otherwise from the args
+	if key != "" {
+		if len(range_widths) > 0 {
+			return nil, errors.New("range widths can't be given with --key, as the key derives them")
+		}
+		return lib.CreateRangeTableArray(lib.RangeWidthsFromKey(key))
+	}
+	return lib.CreateRangeTableArray(lib.RangeWidthsFromArgs(range_widths))
+}
+
+func pvdLsbThreshold(cmd *cobra.Command, range_table [][]int, key string, lsb_threshold int, lsb_bits int) int {
+	// A keyed range table won't usually have a range ending at the default threshold,
+	// so unless one is given, take it from the table
+	if key == "" || cmd.Flags().Changed("threshold") {
+		return lsb_threshold
+	}
+	return lib.LsbThresholdFromTable(range_table, lsb_bits)
+}
+
+func pvdOrientKey(key string, orient bool, scheme string) (string, error) {
+	// Block orientation is only keyed if asked for, and only blocks can be oriented
+	if !orient {
+		return "", nil
+	}
+	if key == "" {
+		return "", errors.New("--orient requires a --key")
+	}
+	if scheme == "pair" {
+		return "", errors.New("--orient can only be used with block schemes ('tri' or 'four')")
+	}
+	return key, nil
+}
+
 func init() {
 	// Add commands
 	rootCmd.AddCommand(pvdCmd)
@@ -132,15 +260,24 @@ func init() {
 	// Add flags
 	pvdCmd.PersistentFlags().StringP("direction", "d", "row", "(Default 'row') Which direction to iterate through the image. Either 'row' or 'column'.")
 	pvdCmd.PersistentFlags().BoolP("zigzag", "z", false, "(Default true) Whether to 'zigzag' across rows/cols.")
-	pvdCmd.PersistentFlags().StringP("plane", "p", "R", "(Default 'R') If an RGBA image is given, whether to embed within 'R', 'G', 'B' or 'A' pixel differences.")
+	pvdCmd.PersistentFlags().StringP("plane", "p", "R", "(Default 'R') If an RGBA image is given, which of 'R', 'G', 'B' or 'A' pixel differences to embed within. Several can be listed (i.e., 'RGB') to use each in turn for every pair.")
+	pvdCmd.PersistentFlags().String("scheme", "pair", "(Default 'pair') Pixel differencing scheme. Either 'pair', 'tri' (2x2 blocks) or 'four' (3x3 blocks).")
+	pvdCmd.PersistentFlags().Bool("hybrid", false, "(Default false) Use LSB replacement for pairs with a difference at or below --threshold, and PVD for the rest.")
+	pvdCmd.PersistentFlags().Int("threshold", 15, "(Default 15) Hybrid mode only. Highest pixel difference to use LSB replacement for. Must end a range in the range table. With --key, defaults to the end of the first range that fits --lsb-bits.")
+	pvdCmd.PersistentFlags().Int("lsb-bits", 3, "(Default 3) Hybrid mode only. Number of LSBs to replace in each pixel of a smooth pair.")
+
+	pvdCmd.PersistentFlags().StringP("key", "k", "", "(Optional) Passphrase to derive a randomised range table from, instead of giving range widths.")
+	pvdCmd.PersistentFlags().Bool("orient", false, "(Default false) Block schemes only. Randomly mirror/transpose each block based off --key.")
 
 	pvdEmbedCmd.Flags().StringP("secret", "s", "", "(Required) A file to be embedded in the image.")
 	pvdEmbedCmd.Flags().StringP("cover", "c", "", "(Required) A cover image data embedded within.")
 	pvdEmbedCmd.Flags().StringP("output", "o", "output.png", "(Default 'output.png') Output image path.")
+	pvdEmbedCmd.Flags().String("format", "", "(Optional) Output image format: png, bmp, pgm, ppm, pnm, pam, pgm-plain or ppm-plain. Otherwise, chosen by the output file extension.")
 	pvdEmbedCmd.MarkFlagRequired("secret")
 	pvdEmbedCmd.MarkFlagRequired("cover")
 
 	pvdExtractCmd.Flags().StringP("input", "i", "", "(Required) Input file with embedded data inside.")
 	pvdExtractCmd.Flags().StringP("output", "o", "extracted.dat", "(Default 'output.dat') Output extracted data file.")
+	pvdExtractCmd.Flags().Bool("auto", false, "(Default false) Try every range table preset (and any range widths given), direction and zigzag setting, and keep the most plausible result.")
 	pvdExtractCmd.MarkFlagRequired("input")
 }
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"stegogo/lib"
+
+	"github.com/spf13/cobra"
+)
+
+// sanitizeCmd represents the sanitize command
+var sanitizeCmd = &cobra.Command{
+	Use:   "sanitize",
+	Short: "Strip metadata and trailing data",
+	Long: `Remove everything from an image that could carry hidden data without changing its pixels, and
+report what was removed. The pixels themselves are not touched, so this does not remove LSB,
+PVD or bit plane steganography.
+
+Categories are: exif, xmp, iptc, icc, comment, jfif, adobe, app (any other APPn segment),
+text, colour (PNG sRGB, gAMA and cHRM chunks), ancillary (any other PNG ancillary chunk) and
+trailing. Categories, JPEG markers (e.g. APP2) or PNG chunk types (e.g. tIME) passed to --keep
+are left in place. GIF and BMP images only have trailing data removed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		keep, _ := cmd.Flags().GetStringSlice("keep")
+		dry_run, _ := cmd.Flags().GetBool("dry-run")
+		if output_file_path == "" {
+			output_file_path = "sanitized" + filepath.Ext(input_file_path)
+		}
+
+		// Read input
+		input_bytes, err := ioutil.ReadFile(input_file_path)
+		if err != nil {
+			return err
+		}
+		output_bytes, removed, err := lib.Sanitize(input_bytes, keep)
+		if err != nil {
+			return err
+		}
+
+		// Report
+		removed_bytes := 0
+		for _, removal := range removed {
+			fmt.Printf("Removed %-9s %-15s offset %8d %8d bytes\n", removal.Category, removal.Name, removal.Offset, removal.Size)
+			removed_bytes += removal.Size
+		}
+		if len(removed) == 0 {
+			fmt.Println("Nothing to remove.")
+		} else {
+			fmt.Printf("Removed %d items, %d bytes in total.\n", len(removed), removed_bytes)
+		}
+		if dry_run {
+			return nil
+		}
+		return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sanitizeCmd)
+
+	sanitizeCmd.Flags().StringP("input", "i", "", "(Required) Input image file.")
+	sanitizeCmd.Flags().StringP("output", "o", "", "(Default 'sanitized' with the input's extension) Output image path.")
+	sanitizeCmd.Flags().StringSliceP("keep", "k", lib.SanitizeDefaultKeep, "(Optional) Comma-separated categories, JPEG markers or PNG chunk types to keep. Pass '' to remove everything.")
+	sanitizeCmd.Flags().Bool("dry-run", false, "(Default false) Only report what would be removed.")
+	sanitizeCmd.MarkFlagRequired("input")
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"stegogo/lib"
+
+	"github.com/spf13/cobra"
+)
+
+// segmentCmd represents the segment command
+var segmentCmd = &cobra.Command{
+	Use:   "segment",
+	Short: "JPEG COM/APPn segments",
+	Long: `List, embed and extract payloads in JPEG comment (COM) and application (APP0-APP15) segments.
+
+Payloads larger than the 64 KiB segment limit are split across several segments, each tagged
+with an index so they can be reassembled on extraction. With --raw, the payload is written to
+or read from the segments as-is instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		os.Exit(1)
+	},
+}
+
+var segmentListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List segments",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+
+		// Read segments
+		sl, err := lib.OpenJpegSegments(input_file_path)
+		if err != nil {
+			return err
+		}
+		for _, line := range lib.JpegSegmentSummary(sl) {
+			fmt.Println(line)
+		}
+		return nil
+	},
+}
+
+var segmentEmbedCmd = &cobra.Command{
+	Use:   "embed",
+	Short: "Embed data",
+	Long:  "Embed a file within JPEG COM or APPn segments.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		secret_file_path, _ := cmd.Flags().GetString("secret")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		marker_name, _ := cmd.Flags().GetString("marker")
+		raw, _ := cmd.Flags().GetBool("raw")
+
+		// Read input
+		marker, err := lib.ParseJpegMarker(marker_name)
+		if err != nil {
+			return err
+		}
+		sl, err := lib.OpenJpegSegments(input_file_path)
+		if err != nil {
+			return err
+		}
+		secret_bytes, err := ioutil.ReadFile(secret_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Embed and write to file
+		sl, err = lib.EmbedJpegSegments(sl, marker, secret_bytes, raw)
+		if err != nil {
+			return err
+		}
+		return lib.WriteJpegSegments(sl, output_file_path)
+	},
+}
+
+var segmentExtractCmd = &cobra.Command{
+	Use:   "extract",
+	Short: "Extract data",
+	Long:  "Extract and reassemble a file from JPEG COM or APPn segments.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		marker_name, _ := cmd.Flags().GetString("marker")
+		raw, _ := cmd.Flags().GetBool("raw")
+
+		// Read input
+		marker, err := lib.ParseJpegMarker(marker_name)
+		if err != nil {
+			return err
+		}
+		sl, err := lib.OpenJpegSegments(input_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Extract and write to file
+		output_bytes, err := lib.ExtractJpegSegments(sl, marker, raw)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+	},
+}
+
+func init() {
+	// Add commands
+	rootCmd.AddCommand(segmentCmd)
+	segmentCmd.AddCommand(segmentListCmd)
+	segmentCmd.AddCommand(segmentEmbedCmd)
+	segmentCmd.AddCommand(segmentExtractCmd)
+
+	// Add flags
+	segmentCmd.PersistentFlags().StringP("marker", "m", "COM", "(Default 'COM') Segment marker to use. Either 'COM' or 'APP0'-'APP15'.")
+	segmentCmd.PersistentFlags().Bool("raw", false, "(Default false) Write/read the payload as-is, without splitting or reassembly headers.")
+
+	segmentListCmd.Flags().StringP("input", "i", "", "(Required) Input JPEG file.")
+	segmentListCmd.MarkFlagRequired("input")
+
+	segmentEmbedCmd.Flags().StringP("input", "i", "", "(Required) Input JPEG file.")
+	segmentEmbedCmd.Flags().StringP("secret", "s", "", "(Required) A file to be embedded in the image.")
+	segmentEmbedCmd.Flags().StringP("output", "o", "output.jpg", "(Default 'output.jpg') Output image path.")
+	segmentEmbedCmd.MarkFlagRequired("input")
+	segmentEmbedCmd.MarkFlagRequired("secret")
+
+	segmentExtractCmd.Flags().StringP("input", "i", "", "(Required) Input JPEG file with embedded data inside.")
+	segmentExtractCmd.Flags().StringP("output", "o", "extracted.dat", "(Default 'extracted.dat') Output extracted data file.")
+	segmentExtractCmd.MarkFlagRequired("input")
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"stegogo/lib"
+
+	"github.com/spf13/cobra"
+)
+
+// spectrogramCmd represents the spectrogram command
+var spectrogramCmd = &cobra.Command{
+	Use:   "spectrogram",
+	Short: "Images in audio spectrograms",
+	Long: `Hide a picture in the frequency content of audio, so it appears when the audio is viewed
+in a spectrogram, and render the spectrogram of a WAV to an image to look for one.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		os.Exit(1)
+	},
+}
+
+var spectrogramEmbedCmd = &cobra.Command{
+	Use:   "embed",
+	Short: "Synthesise audio from an image",
+	Long: `Synthesise a mono 16-bit WAV whose spectrogram shows the image (in greyscale) by inverse
+short-time Fourier transform. Image columns are spread over the duration, and rows over the
+frequency range, highest at the top. Brighter pixels are louder, over a 60 dB range.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		image_file_path, _ := cmd.Flags().GetString("cover")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		sample_rate, _ := cmd.Flags().GetInt("sample-rate")
+		duration, _ := cmd.Flags().GetFloat64("duration")
+		low_freq, _ := cmd.Flags().GetFloat64("low")
+		high_freq, _ := cmd.Flags().GetFloat64("high")
+
+		// Open image
+		img, err := lib.OpenImage(image_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Synthesise
+		wav, err := lib.SynthesiseSpectrogram(img, sample_rate, duration, low_freq, high_freq)
+		if err != nil {
+			return err
+		}
+
+		// Write audio to file
+		return lib.SaveWav(wav, output_file_path)
+	},
+}
+
+var spectrogramRenderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Render a WAV's spectrogram",
+	Long:  "Render the spectrogram of a WAV file (mixed to mono) to an image, with time left to right and frequency from 0 Hz at the bottom to half the sample rate at the top.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		output_format, _ := cmd.Flags().GetString("format")
+		fft_size, _ := cmd.Flags().GetInt("fft-size")
+		hop, _ := cmd.Flags().GetInt("hop")
+		if hop == 0 {
+			hop = fft_size / 4
+		}
+
+		// Open input
+		wav, err := lib.OpenWav(input_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Render
+		img, err := lib.RenderSpectrogram(wav, fft_size, hop)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Spectrogram is %dx%d, %.1f Hz per row.\n", img.Bounds().Dx(), img.Bounds().Dy(), float64(wav.SampleRate)/float64(fft_size))
+
+		// Write image to file
+		return lib.SaveImage(img, output_file_path, output_format)
+	},
+}
+
+func init() {
+	// Add commands
+	rootCmd.AddCommand(spectrogramCmd)
+	spectrogramCmd.AddCommand(spectrogramEmbedCmd)
+	spectrogramCmd.AddCommand(spectrogramRenderCmd)
+
+	// Add flags
+	spectrogramEmbedCmd.Flags().StringP("cover", "c", "", "(Required) Image to draw in the spectrogram.")
+	spectrogramEmbedCmd.Flags().StringP("output", "o", "output.wav", "(Default 'output.wav') Output WAV path.")
+	spectrogramEmbedCmd.Flags().Int("sample-rate", 44100, "(Default 44100) Sample rate of the audio, in Hz.")
+	spectrogramEmbedCmd.Flags().Float64("duration", 5, "(Default 5) Length of the audio, in seconds.")
+	spectrogramEmbedCmd.Flags().Float64("low", 1000, "(Default 1000) Frequency of the bottom of the image, in Hz.")
+	spectrogramEmbedCmd.Flags().Float64("high", 16000, "(Default 16000) Frequency of the top of the image, in Hz.")
+	spectrogramEmbedCmd.MarkFlagRequired("cover")
+
+	spectrogramRenderCmd.Flags().StringP("input", "i", "", "(Required) Input WAV file.")
+	spectrogramRenderCmd.Flags().StringP("output", "o", "spectrogram.png", "(Default 'spectrogram.png') Output image path.")
+	spectrogramRenderCmd.Flags().String("format", "", "(Optional) Output image format: png, bmp, pgm, ppm, pnm, pam, pgm-plain or ppm-plain. Otherwise, chosen by the output file extension.")
+	spectrogramRenderCmd.Flags().Int("fft-size", 1024, "(Default 1024) Samples per FFT frame, a power of two. Larger sizes give finer frequency detail.")
+	spectrogramRenderCmd.Flags().Int("hop", 0, "(Default a quarter of the FFT size) Samples between frames.")
+	spectrogramRenderCmd.MarkFlagRequired("input")
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"stegogo/lib"
+
+	"github.com/spf13/cobra"
+)
+
+// textCmd represents the text command
+var textCmd = &cobra.Command{
+	Use:   "text",
+	Short: "Text steganography",
+	Long: `Hide data in plain text, where it can't be seen when the text is displayed, and detect
+text that may hide data.
+
+Methods (--method):
+  whitespace  SNOW-style: 3 bits at a time as runs of 0-7 spaces ended by tabs, appended to the
+              ends of lines. Blank lines are added if the cover runs out.
+  zerowidth   Each byte as eight zero-width characters (U+200B for 0, U+200C for 1) after the
+              end of a word.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		os.Exit(1)
+	},
+}
+
+var textEmbedCmd = &cobra.Command{
+	Use:   "embed",
+	Short: "Embed data",
+	Long:  "Embed a file within a cover text.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		cover_file_path, _ := cmd.Flags().GetString("cover")
+		secret_file_path, _ := cmd.Flags().GetString("secret")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		method, _ := cmd.Flags().GetString("method")
+		line_length, _ := cmd.Flags().GetInt("line-length")
+
+		// Open cover and secret
+		cover_bytes, err := ioutil.ReadFile(cover_file_path)
+		if err != nil {
+			return err
+		}
+		secret_bytes, err := ioutil.ReadFile(secret_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Embed
+		var output string
+		switch method {
+		case "whitespace":
+			output = lib.EmbedWhitespace(string(cover_bytes), secret_bytes, line_length)
+		case "zerowidth":
+			output = lib.EmbedZeroWidth(string(cover_bytes), secret_bytes)
+		default:
+			return fmt.Errorf("invalid method '%s'. Must be one of: %s", method, strings.Join(lib.TextMethods, ", "))
+		}
+
+		// Write text to file
+		return ioutil.WriteFile(output_file_path, []byte(output), 0644)
+	},
+}
+
+var textExtractCmd = &cobra.Command{
+	Use:   "extract",
+	Short: "Extract data",
+	Long:  "Extract data embedded with 'text embed'.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		method, _ := cmd.Flags().GetString("method")
+
+		// Open input
+		input_bytes, err := ioutil.ReadFile(input_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Extract
+		var output_bytes []byte
+		switch method {
+		case "whitespace":
+			output_bytes = lib.ExtractWhitespace(string(input_bytes))
+		case "zerowidth":
+			output_bytes = lib.ExtractZeroWidth(string(input_bytes))
+		default:
+			return fmt.Errorf("invalid method '%s'. Must be one of: %s", method, strings.Join(lib.TextMethods, ", "))
+		}
+
+		// Write to file
+		return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+	},
+}
+
+var textDetectCmd = &cobra.Command{
+	Use:   "detect",
+	Short: "Detect hidden data",
+	Long:  "Report invisible characters (zero-width, bidirectional controls, tags and other format characters) and trailing whitespace in a text, with their line and column.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		// Open input
+		input_bytes, err := ioutil.ReadFile(input_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Report findings
+		findings := lib.DetectTextStego(string(input_bytes))
+		if len(findings) == 0 {
+			fmt.Println("No invisible characters or trailing whitespace found.")
+			return nil
+		}
+		counts := make(map[string]int)
+		var order []string
+		for i, finding := range findings {
+			if limit == 0 || i < limit {
+				fmt.Printf("%d:%d\t%s\n", finding.Line, finding.Column, finding.Description)
+			}
+			kind := finding.Description
+			if strings.Contains(kind, "trailing") {
+				kind = "lines with trailing whitespace"
+			}
+			if counts[kind] == 0 {
+				order = append(order, kind)
+			}
+			counts[kind]++
+		}
+		if limit > 0 && len(findings) > limit {
+			fmt.Printf("... and %d more\n", len(findings)-limit)
+		}
+		fmt.Println("Summary:")
+		for _, kind := range order {
+			fmt.Printf("  %d x %s\n", counts[kind], kind)
+		}
+		return nil
+	},
+}
+
+func init() {
+	// Add commands
+	rootCmd.AddCommand(textCmd)
+	textCmd.AddCommand(textEmbedCmd)
+	textCmd.AddCommand(textExtractCmd)
+	textCmd.AddCommand(textDetectCmd)
+
+	// Add flags
+	textEmbedCmd.Flags().StringP("cover", "c", "", "(Required) A cover text file.")
+	textEmbedCmd.Flags().StringP("secret", "s", "", "(Required) A file to be embedded in the text.")
+	textEmbedCmd.Flags().StringP("output", "o", "output.txt", "(Default 'output.txt') Output text path.")
+	textEmbedCmd.Flags().String("method", "whitespace", "(Default 'whitespace') Method to use, 'whitespace' or 'zerowidth'.")
+	textEmbedCmd.Flags().Int("line-length", 80, "(Default 80) For whitespace, the length lines are filled up to, though every line gets some data.")
+	textEmbedCmd.MarkFlagRequired("cover")
+	textEmbedCmd.MarkFlagRequired("secret")
+
+	textExtractCmd.Flags().StringP("input", "i", "", "(Required) Input text with embedded data.")
+	textExtractCmd.Flags().StringP("output", "o", "extracted.dat", "(Default 'extracted.dat') Output extracted data file.")
+	textExtractCmd.Flags().String("method", "whitespace", "(Default 'whitespace') Method to use, 'whitespace' or 'zerowidth'.")
+	textExtractCmd.MarkFlagRequired("input")
+
+	textDetectCmd.Flags().StringP("input", "i", "", "(Required) Input text to check.")
+	textDetectCmd.Flags().Int("limit", 50, "(Default 50) Most findings to list individually, or 0 for all.")
+	textDetectCmd.MarkFlagRequired("input")
+}
@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"stegogo/lib"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+	"github.com/spf13/cobra"
+)
+
+// Mean luminance difference above which a thumbnail is reported as not matching its image
+const thumbnailMismatchThreshold = 20
+
+// thumbnailCmd represents the thumbnail command
+var thumbnailCmd = &cobra.Command{
+	Use:   "thumbnail",
+	Short: "EXIF thumbnails",
+	Long: `Extract, replace and hide data in the JPEG thumbnail stored in IFD1 of a JPEG's EXIF data.
+
+A thumbnail that doesn't match its main image is a classic leak: editors often update the image
+but not the thumbnail, and it can also be swapped out on purpose to carry a different picture.
+
+Thumbnails must be JPEGs. A replacement JPEG is stored byte-for-byte, so payloads hidden in it
+with 'segment embed' or 'append embed' survive. Other formats (e.g. the PNG output of 'lsb embed'
+or 'bp embed') are re-encoded, which destroys pixel-level embedding.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		os.Exit(1)
+	},
+}
+
+var thumbnailExtractCmd = &cobra.Command{
+	Use:   "extract",
+	Short: "Extract and analyse thumbnail",
+	Long: `Save the EXIF thumbnail, optionally render it as a PNG, compare it against the main image,
+and save any data hidden after the thumbnail's end marker.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		render_file_path, _ := cmd.Flags().GetString("render")
+		secret_file_path, _ := cmd.Flags().GetString("secret")
+
+		// Read thumbnail
+		raw_exif, err := exif.SearchFileAndExtractExif(input_file_path)
+		if err != nil {
+			return err
+		}
+		thumbnail_bytes, err := lib.ExifThumbnail(raw_exif)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(output_file_path, thumbnail_bytes, 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Thumbnail: %d bytes, saved to %s\n", len(thumbnail_bytes), output_file_path)
+
+		// Hidden data after the thumbnail
+		end, err := lib.FindImageEnd(thumbnail_bytes)
+		if err != nil {
+			fmt.Printf("WARNING: Thumbnail is not a well-formed JPEG (%s).\n", err)
+		} else if end < len(thumbnail_bytes) {
+			fmt.Printf("Thumbnail is followed by %d bytes of trailing data, saved to %s\n", len(thumbnail_bytes)-end, secret_file_path)
+			if file_type := lib.DetectFileType(thumbnail_bytes[end:]); file_type != "" {
+				fmt.Printf("Trailing data looks like: %s\n", file_type)
+			}
+			err = ioutil.WriteFile(secret_file_path, thumbnail_bytes[end:], 0644)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Compare against main image
+		thumbnail_img, _, err := image.Decode(bytes.NewReader(thumbnail_bytes))
+		if err != nil {
+			return fmt.Errorf("thumbnail can't be decoded: %s", err)
+		}
+		main_img, err := lib.OpenImage(input_file_path)
+		if err != nil {
+			return err
+		}
+		thumb_bounds, main_bounds := thumbnail_img.Bounds(), main_img.Bounds()
+		fmt.Printf("Thumbnail size: %dx%d, image size: %dx%d\n", thumb_bounds.Dx(), thumb_bounds.Dy(), main_bounds.Dx(), main_bounds.Dy())
+		thumb_ratio := float64(thumb_bounds.Dx()) / float64(thumb_bounds.Dy())
+		main_ratio := float64(main_bounds.Dx()) / float64(main_bounds.Dy())
+		if thumb_ratio/main_ratio > 1.1 || main_ratio/thumb_ratio > 1.1 {
+			fmt.Printf("WARNING: Thumbnail aspect ratio %.2f doesn't match image aspect ratio %.2f.\n", thumb_ratio, main_ratio)
+		}
+		difference := lib.ThumbnailDifference(main_img, thumbnail_img)
+		fmt.Printf("Mean luminance difference from image: %.2f\n", difference)
+		if difference > thumbnailMismatchThreshold {
+			fmt.Println("WARNING: Thumbnail doesn't match the image. It may be stale or have been swapped.")
+		}
+
+		// Render for viewing
+		if render_file_path != "" {
+			f, err := os.Create(render_file_path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return png.Encode(f, thumbnail_img)
+		}
+		return nil
+	},
+}
+
+var thumbnailEmbedCmd = &cobra.Command{
+	Use:   "embed",
+	Short: "Replace thumbnail and/or hide data in it",
+	Long: `Replace the EXIF thumbnail with another image (--thumbnail), and/or hide a file after the
+thumbnail's end marker (--secret). Without --thumbnail, the existing thumbnail is kept, or one
+is generated from the image if it has none.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		thumbnail_file_path, _ := cmd.Flags().GetString("thumbnail")
+		secret_file_path, _ := cmd.Flags().GetString("secret")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		if thumbnail_file_path == "" && secret_file_path == "" {
+			return errors.New("--thumbnail and/or --secret must be given")
+		}
+
+		// Read EXIF, or start a new one
+		sl, err := lib.OpenJpegSegments(input_file_path)
+		if err != nil {
+			return fmt.Errorf("thumbnail embed only supports JPEG images (%s)", err)
+		}
+		ib, err := sl.ConstructExifBuilder()
+		if err != nil {
+			return err
+		}
+		var byte_order binary.ByteOrder = exifcommon.EncodeDefaultByteOrder
+		var thumbnail_bytes []byte
+		root_ifd, raw_exif, err := sl.Exif()
+		if err == nil {
+			byte_order = root_ifd.ByteOrder()
+			thumbnail_bytes, _ = lib.ExifThumbnail(raw_exif)
+		}
+
+		// Choose thumbnail
+		if thumbnail_file_path != "" {
+			thumbnail_bytes, err = ioutil.ReadFile(thumbnail_file_path)
+			if err != nil {
+				return err
+			}
+			if lib.DetectFileType(thumbnail_bytes) != "jpeg" {
+				fmt.Println("WARNING: Replacement thumbnail isn't a JPEG, so it will be re-encoded. Any data hidden in its pixels will be lost.")
+				thumbnail_img, err := lib.OpenImage(thumbnail_file_path)
+				if err != nil {
+					return err
+				}
+				thumbnail_bytes, err = lib.EncodeThumbnail(thumbnail_img)
+				if err != nil {
+					return err
+				}
+			}
+		} else if thumbnail_bytes == nil {
+			fmt.Println("Image has no thumbnail, generating one.")
+			main_img, err := lib.OpenImage(input_file_path)
+			if err != nil {
+				return err
+			}
+			thumbnail_bytes, err = lib.EncodeThumbnail(main_img)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Hide secret after the thumbnail
+		if secret_file_path != "" {
+			secret_bytes, err := ioutil.ReadFile(secret_file_path)
+			if err != nil {
+				return err
+			}
+			thumbnail_bytes, err = lib.AppendTrailingData(thumbnail_bytes, secret_bytes)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Write changes
+		err = lib.SetExifThumbnail(ib, byte_order, thumbnail_bytes)
+		if err != nil {
+			return err
+		}
+		err = sl.SetExif(ib)
+		if err != nil {
+			return err
+		}
+		_, exif_segment, err := sl.FindExif()
+		if err != nil {
+			return err
+		}
+		if len(exif_segment.Data) > lib.JpegMaxSegmentData {
+			return fmt.Errorf("EXIF data would be %d bytes, but must fit in a %d byte APP1 segment", len(exif_segment.Data), lib.JpegMaxSegmentData)
+		}
+		return lib.WriteJpegSegments(sl, output_file_path)
+	},
+}
+
+func init() {
+	// Add commands
+	rootCmd.AddCommand(thumbnailCmd)
+	thumbnailCmd.AddCommand(thumbnailExtractCmd)
+	thumbnailCmd.AddCommand(thumbnailEmbedCmd)
+
+	// Add flags
+	thumbnailExtractCmd.Flags().StringP("input", "i", "", "(Required) Input JPEG image.")
+	thumbnailExtractCmd.Flags().StringP("output", "o", "thumbnail.jpg", "(Default 'thumbnail.jpg') Output thumbnail file, exactly as stored.")
+	thumbnailExtractCmd.Flags().StringP("render", "r", "", "(Optional) Also save the decoded thumbnail as a PNG to this path.")
+	thumbnailExtractCmd.Flags().StringP("secret", "s", "extracted.dat", "(Default 'extracted.dat') Output file for data hidden after the thumbnail, if any.")
+	thumbnailExtractCmd.MarkFlagRequired("input")
+
+	thumbnailEmbedCmd.Flags().StringP("input", "i", "", "(Required) Input JPEG image.")
+	thumbnailEmbedCmd.Flags().StringP("thumbnail", "t", "", "(Optional) Replacement thumbnail image. JPEGs are stored as-is, anything else is scaled and re-encoded.")
+	thumbnailEmbedCmd.Flags().StringP("secret", "s", "", "(Optional) A file to hide after the thumbnail's end marker.")
+	thumbnailEmbedCmd.Flags().StringP("output", "o", "output.jpg", "(Default 'output.jpg') Output image path.")
+	thumbnailEmbedCmd.MarkFlagRequired("input")
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"stegogo/lib"
+
+	"github.com/spf13/cobra"
+)
+
+// wavCmd represents the wav command
+var wavCmd = &cobra.Command{
+	Use:   "wav",
+	Short: "WAV audio LSB",
+	Long: `Least Significant Bit steganography for PCM WAV audio (8, 16 or 24-bit, mono or stereo).
+The secret is embedded frame by frame into chosen bits of each channel's samples, given
+like image bit planes: a channel, L or R, then a bit position, 0 being the least significant,
+i.e., "L0 R0". Only sample bits change, so headers and other chunks are kept exactly.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		os.Exit(1)
+	},
+}
+
+var wavEmbedCmd = &cobra.Command{
+	Use:   "embed",
+	Short: "Embed data",
+	Long:  "Embed a secret within a WAV file via Least Significant Bit steganography.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		// Ensure we get at least 1 import
+		if len(args) < 1 {
+			return errors.New(`sample bit positions must be given, i.e., "L0", or "L0 R0"`)
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, sampleplane_args []string) error {
+		// Parse input flags
+		secret_file_path, _ := cmd.Flags().GetString("secret")
+		cover_file_path, _ := cmd.Flags().GetString("cover")
+		output_file_path, _ := cmd.Flags().GetString("output")
+
+		// Parse secret
+		secret_bits, err := lib.FilepathToBitstream(secret_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Open cover file
+		cover, err := lib.OpenWav(cover_file_path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Cover: %d-bit, %d channel(s), %d Hz.\n", cover.BitsPerSample, cover.Channels, cover.SampleRate)
+
+		// Run embed operation
+		edited_wav, err := lib.EmbedWavLsb(sampleplane_args, secret_bits, cover)
+		if err != nil {
+			return err
+		}
+
+		// Write audio to file
+		return lib.SaveWav(edited_wav, output_file_path)
+	},
+}
+
+var wavExtractCmd = &cobra.Command{
+	Use:   "extract",
+	Short: "Extract data",
+	Long:  "Extract secret data from within a WAV file via Least Significant Bit steganography. All of the audio's capacity is extracted.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New(`sample bit positions must be given, i.e., "L0", or "L0 R0"`)
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, sampleplane_args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("input")
+		output_file_path, _ := cmd.Flags().GetString("output")
+
+		// Open input file
+		input_wav, err := lib.OpenWav(input_file_path)
+		if err != nil {
+			return err
+		}
+
+		// Run extraction
+		extracted_bits, err := lib.ExtractWavLsb(sampleplane_args, input_wav)
+		if err != nil {
+			return err
+		}
+
+		// Write to file
+		return ioutil.WriteFile(output_file_path, lib.BitstreamToBytes(extracted_bits), 0644)
+	},
+}
+
+func init() {
+	// Add commands
+	rootCmd.AddCommand(wavCmd)
+	wavCmd.AddCommand(wavEmbedCmd)
+	wavCmd.AddCommand(wavExtractCmd)
+
+	// Add flags
+	wavEmbedCmd.Flags().StringP("secret", "s", "", "(Required) A file to be embedded in the audio.")
+	wavEmbedCmd.Flags().StringP("cover", "c", "", "(Required) A cover WAV file to have data embedded within.")
+	wavEmbedCmd.Flags().StringP("output", "o", "output.wav", "(Default 'output.wav') Output WAV path.")
+	wavEmbedCmd.MarkFlagRequired("secret")
+	wavEmbedCmd.MarkFlagRequired("cover")
+
+	wavExtractCmd.Flags().StringP("input", "i", "", "(Required) Input WAV file with embedded data inside.")
+	wavExtractCmd.Flags().StringP("output", "o", "extracted.dat", "(Default 'extracted.dat') Output extracted data file.")
+	wavExtractCmd.MarkFlagRequired("input")
+}
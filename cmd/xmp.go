@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"stegogo/lib"
+
+	"github.com/spf13/cobra"
+)
+
+// xmpCmd represents the xmp command
+var xmpCmd = &cobra.Command{
+	Use:   "xmp",
+	Short: "XMP Data Manipulation",
+	Long: `Read, write and hide payloads in the XMP packet of a JPEG (APP1 segment) or PNG (iTXt chunk
+with the keyword 'XML:com.adobe.xmp').
+
+Payloads are stored as base64 in a property of a custom namespace, which XMP-aware software
+keeps but doesn't display.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		os.Exit(1)
+	},
+}
+
+var xmpListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List XMP properties",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("image")
+		as_json, _ := cmd.Flags().GetBool("json")
+		raw, _ := cmd.Flags().GetBool("raw")
+
+		// Read packet
+		input_bytes, err := ioutil.ReadFile(input_file_path)
+		if err != nil {
+			return err
+		}
+		packet, err := lib.ReadXmp(input_bytes)
+		if err != nil {
+			return err
+		}
+		if raw {
+			fmt.Println(string(packet))
+			return nil
+		}
+
+		properties, err := lib.XmpProperties(packet)
+		if err != nil {
+			fmt.Printf("WARNING: XMP packet is not well-formed (%s).\n", err)
+		}
+		if as_json {
+			json_bytes, err := json.MarshalIndent(properties, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(json_bytes))
+			return nil
+		}
+
+		// Display properties to user, grouped by namespace
+		current_namespace := ""
+		for _, property := range properties {
+			if property.Namespace != current_namespace {
+				current_namespace = property.Namespace
+				fmt.Printf("********** %s\n", current_namespace)
+			}
+			fmt.Printf("%s: %s\n", property.Path, property.Value)
+		}
+		return nil
+	},
+}
+
+var xmpWriteCmd = &cobra.Command{
+	Use:   "write",
+	Short: "Write XMP packet",
+	Long:  "Replace the XMP packet of an image with the contents of an XML file, e.g. one saved and edited from 'exif xmp extract --packet'.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("image")
+		packet_file_path, _ := cmd.Flags().GetString("packet")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		if output_file_path == "" {
+			output_file_path = "output" + filepath.Ext(input_file_path)
+		}
+
+		// Read input
+		input_bytes, err := ioutil.ReadFile(input_file_path)
+		if err != nil {
+			return err
+		}
+		packet, err := ioutil.ReadFile(packet_file_path)
+		if err != nil {
+			return err
+		}
+		if _, err := lib.XmpProperties(packet); err != nil {
+			fmt.Printf("WARNING: XMP packet is not well-formed (%s).\n", err)
+		}
+
+		// Write to file
+		output_bytes, err := lib.WriteXmp(input_bytes, packet)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+	},
+}
+
+var xmpEmbedCmd = &cobra.Command{
+	Use:   "embed",
+	Short: "Embed data",
+	Long:  "Embed a file in a custom namespace property of an image's XMP packet, creating the packet if needed. Other properties are kept.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("image")
+		secret_file_path, _ := cmd.Flags().GetString("secret")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		prefix, _ := cmd.Flags().GetString("prefix")
+		property, _ := cmd.Flags().GetString("property")
+		if output_file_path == "" {
+			output_file_path = "output" + filepath.Ext(input_file_path)
+		}
+
+		// Read input
+		input_bytes, err := ioutil.ReadFile(input_file_path)
+		if err != nil {
+			return err
+		}
+		secret_bytes, err := ioutil.ReadFile(secret_file_path)
+		if err != nil {
+			return err
+		}
+		packet, _ := lib.ReadXmp(input_bytes)
+
+		// Embed and write to file
+		packet, err = lib.EmbedXmpPayload(packet, namespace, prefix, property, secret_bytes)
+		if err != nil {
+			return err
+		}
+		output_bytes, err := lib.WriteXmp(input_bytes, packet)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+	},
+}
+
+var xmpExtractCmd = &cobra.Command{
+	Use:   "extract",
+	Short: "Extract data",
+	Long:  "Extract a file embedded with 'exif xmp embed', or save the whole XMP packet with --packet.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Parse input flags
+		input_file_path, _ := cmd.Flags().GetString("image")
+		output_file_path, _ := cmd.Flags().GetString("output")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		property, _ := cmd.Flags().GetString("property")
+		whole_packet, _ := cmd.Flags().GetBool("packet")
+
+		// Read packet
+		input_bytes, err := ioutil.ReadFile(input_file_path)
+		if err != nil {
+			return err
+		}
+		packet, err := lib.ReadXmp(input_bytes)
+		if err != nil {
+			return err
+		}
+		if whole_packet {
+			return ioutil.WriteFile(output_file_path, packet, 0644)
+		}
+
+		// Write to file
+		output_bytes, err := lib.ExtractXmpPayload(packet, namespace, property)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(output_file_path, output_bytes, 0644)
+	},
+}
+
+func init() {
+	// Add commands
+	exifCmd.AddCommand(xmpCmd)
+	xmpCmd.AddCommand(xmpListCmd)
+	xmpCmd.AddCommand(xmpWriteCmd)
+	xmpCmd.AddCommand(xmpEmbedCmd)
+	xmpCmd.AddCommand(xmpExtractCmd)
+
+	// Add flags
+	xmpListCmd.Flags().StringP("image", "i", "", "(Required) Input JPEG or PNG image.")
+	xmpListCmd.Flags().Bool("json", false, "(Default false) Show all properties as JSON.")
+	xmpListCmd.Flags().Bool("raw", false, "(Default false) Show the XMP packet as-is.")
+	xmpListCmd.MarkFlagRequired("image")
+
+	xmpWriteCmd.Flags().StringP("image", "i", "", "(Required) Input JPEG or PNG image.")
+	xmpWriteCmd.Flags().StringP("packet", "x", "", "(Required) XML file holding the new XMP packet.")
+	xmpWriteCmd.Flags().StringP("output", "o", "", "(Default 'output' with the input's extension) Output image path.")
+	xmpWriteCmd.MarkFlagRequired("image")
+	xmpWriteCmd.MarkFlagRequired("packet")
+
+	xmpEmbedCmd.Flags().StringP("image", "i", "", "(Required) Input JPEG or PNG image.")
+	xmpEmbedCmd.Flags().StringP("secret", "s", "", "(Required) Secret data filename to be embedded within XMP.")
+	xmpEmbedCmd.Flags().StringP("output", "o", "", "(Default 'output' with the input's extension) Output image path.")
+	xmpEmbedCmd.Flags().String("namespace", lib.XmpStegogoNamespace, "(Optional) Namespace URI of the payload property.")
+	xmpEmbedCmd.Flags().String("prefix", "stegogo", "(Optional) Namespace prefix of the payload property.")
+	xmpEmbedCmd.Flags().String("property", lib.XmpStegogoProperty, "(Optional) Name of the payload property.")
+	xmpEmbedCmd.MarkFlagRequired("image")
+	xmpEmbedCmd.MarkFlagRequired("secret")
+
+	xmpExtractCmd.Flags().StringP("image", "i", "", "(Required) Input JPEG or PNG image.")
+	xmpExtractCmd.Flags().StringP("output", "o", "output.dat", "(Default 'output.dat') Output extracted data file.")
+	xmpExtractCmd.Flags().String("namespace", lib.XmpStegogoNamespace, "(Optional) Namespace URI of the payload property.")
+	xmpExtractCmd.Flags().String("property", lib.XmpStegogoProperty, "(Optional) Name of the payload property.")
+	xmpExtractCmd.Flags().Bool("packet", false, "(Default false) Save the whole XMP packet instead of a payload.")
+	xmpExtractCmd.MarkFlagRequired("image")
+}
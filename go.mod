@@ -2,15 +2,17 @@ module stegogo
 
 go 1.17
 
-require github.com/spf13/cobra v1.3.0
+require (
+	github.com/dsoprea/go-iptc v0.0.0-20200609062250-162ae6b44feb
+	github.com/dsoprea/go-photoshop-info-format v0.0.0-20200609050348-3db9b63b202c
+	github.com/spf13/cobra v1.3.0
+)
 
 require (
 	github.com/bamiaux/rez v0.0.0-20170731184118-29f4463c688b // indirect
 	github.com/dsoprea/go-exif/v3 v3.0.0-20210625224831-a6301f85c82b // indirect
-	github.com/dsoprea/go-iptc v0.0.0-20200609062250-162ae6b44feb // indirect
 	github.com/dsoprea/go-jpeg-image-structure/v2 v2.0.0-20210512043942-b434301c6836 // indirect
 	github.com/dsoprea/go-logging v0.0.0-20200710184922-b02d349568dd // indirect
-	github.com/dsoprea/go-photoshop-info-format v0.0.0-20200609050348-3db9b63b202c // indirect
 	github.com/dsoprea/go-utility/v2 v2.0.0-20200717064901-2fccff4aa15e // indirect
 	github.com/go-errors/errors v1.1.1 // indirect
 	github.com/go-xmlfmt/xmlfmt v0.0.0-20191208150333-d5b6f63a941b // indirect
@@ -0,0 +1,362 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math/bits"
+)
+
+const (
+	bmpFileHeaderSize = 14
+	bmpInfoHeaderSize = 40
+	bmpV4HeaderSize   = 108
+	bmpCompressionRgb = 0
+	bmpBitfields      = 3
+	bmpAlphaBitfields = 6
+)
+
+type bmpHeader struct {
+	width, height int
+	top_down      bool
+	bpp           int
+	compression   uint32
+	masks         [4]uint32
+	palette       color.Palette
+	pixel_offset  int
+}
+
+func init() {
+	image.RegisterFormat("bmp", "BM", DecodeBmp, DecodeBmpConfig)
+}
+
+func readBmpHeader(data []byte) (bmpHeader, error) {
+	/*
+		Parse the file and info headers, bit masks and palette of a BMP.
+	*/
+	var header bmpHeader
+	if len(data) < bmpFileHeaderSize+12 || string(data[0:2]) != "BM" {
+		return header, errors.New("file is not a BMP")
+	}
+	header.pixel_offset = int(binary.LittleEndian.Uint32(data[10:14]))
+	info := data[bmpFileHeaderSize:]
+	info_size := int(binary.LittleEndian.Uint32(info[0:4]))
+	if len(info) < info_size {
+		return header, errors.New("BMP header is truncated")
+	}
+	palette_entry_size := 4
+	colours_used := 0
+	if info_size == 12 {
+		// OS/2 BITMAPCOREHEADER
+		header.width = int(binary.LittleEndian.Uint16(info[4:6]))
+		header.height = int(binary.LittleEndian.Uint16(info[6:8]))
+		header.bpp = int(binary.LittleEndian.Uint16(info[10:12]))
+		palette_entry_size = 3
+	} else if info_size >= bmpInfoHeaderSize {
+		header.width = int(int32(binary.LittleEndian.Uint32(info[4:8])))
+		header.height = int(int32(binary.LittleEndian.Uint32(info[8:12])))
+		header.bpp = int(binary.LittleEndian.Uint16(info[14:16]))
+		header.compression = binary.LittleEndian.Uint32(info[16:20])
+		colours_used = int(binary.LittleEndian.Uint32(info[32:36]))
+	} else {
+		return header, fmt.Errorf("unsupported BMP header size %d", info_size)
+	}
+	if header.height < 0 {
+		header.height, header.top_down = -header.height, true
+	}
+	if header.width <= 0 || header.height <= 0 {
+		return header, errors.New("BMP has no pixels")
+	}
+
+	// Bit masks, either in the header or just after it
+	mask_offset := info_size
+	switch header.compression {
+	case bmpCompressionRgb:
+		switch header.bpp {
+		case 16:
+			header.masks = [4]uint32{0x7c00, 0x03e0, 0x001f, 0}
+		case 24, 32:
+			header.masks = [4]uint32{0xff0000, 0xff00, 0xff, 0}
+		}
+	case bmpBitfields, bmpAlphaBitfields:
+		mask_count := 3
+		if header.compression == bmpAlphaBitfields || info_size >= 56 {
+			mask_count = 4
+		}
+		if info_size >= 52 {
+			mask_offset = bmpInfoHeaderSize
+		}
+		if len(info) < mask_offset+mask_count*4 {
+			return header, errors.New("BMP bit masks are truncated")
+		}
+		for i := 0; i < mask_count; i++ {
+			header.masks[i] = binary.LittleEndian.Uint32(info[mask_offset+i*4:])
+		}
+		if info_size < 52 {
+			mask_offset += mask_count * 4
+		} else {
+			mask_offset = info_size
+		}
+	default:
+		return header, fmt.Errorf("unsupported BMP compression %d. Only uncompressed BMPs are supported", header.compression)
+	}
+
+	// Palette
+	if header.bpp <= 8 {
+		if colours_used == 0 {
+			colours_used = 1 << header.bpp
+		}
+		palette := info[mask_offset:]
+		if len(palette) < colours_used*palette_entry_size {
+			return header, errors.New("BMP palette is truncated")
+		}
+		for i := 0; i < colours_used; i++ {
+			entry := palette[i*palette_entry_size:]
+			header.palette = append(header.palette, color.RGBA{entry[2], entry[1], entry[0], 0xff})
+		}
+	}
+	switch header.bpp {
+	case 1, 4, 8, 16, 24, 32:
+	default:
+		return header, fmt.Errorf("unsupported BMP bit depth %d", header.bpp)
+	}
+	return header, nil
+}
+
+func DecodeBmpConfig(r io.Reader) (image.Config, error) {
+	/*
+		Read just the headers, bit masks and palette of a BMP, which can take up
+		to the info header size, four masks and 256 palette entries after the
+		file header.
+	*/
+	data := make([]byte, bmpFileHeaderSize+4)
+	_, err := io.ReadFull(r, data)
+	if err != nil {
+		return image.Config{}, errors.New("file is not a BMP")
+	}
+	info_size := int(binary.LittleEndian.Uint32(data[bmpFileHeaderSize:]))
+	if info_size < 12 || info_size > 1<<16 {
+		return image.Config{}, fmt.Errorf("unsupported BMP header size %d", info_size)
+	}
+	rest := make([]byte, info_size-4+4*4+256*4)
+	n, err := io.ReadFull(r, rest)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return image.Config{}, err
+	}
+	header, err := readBmpHeader(append(data, rest[:n]...))
+	if err != nil {
+		return image.Config{}, err
+	}
+	model := color.NRGBAModel
+	if header.palette != nil {
+		model = header.palette
+		if isGrayPalette(header.palette) {
+			model = color.GrayModel
+		}
+	}
+	return image.Config{ColorModel: model, Width: header.width, Height: header.height}, nil
+}
+
+func DecodeBmp(r io.Reader) (image.Image, error) {
+	/*
+		Decode an uncompressed BMP. Images with a palette of 256 greys become
+		image.Gray, other paletted images image.Paletted, and everything else
+		image.NRGBA, so pixel values are exactly those in the file.
+	*/
+	data, err := ioutil.ReadAll(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	header, err := readBmpHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	stride := (header.width*header.bpp + 31) / 32 * 4
+	if header.pixel_offset+stride*header.height > len(data) {
+		return nil, errors.New("BMP pixel data is truncated")
+	}
+	row := func(y int) []byte {
+		if !header.top_down {
+			y = header.height - 1 - y
+		}
+		start := header.pixel_offset + y*stride
+		return data[start : start+stride]
+	}
+	bounds := image.Rect(0, 0, header.width, header.height)
+
+	// Paletted
+	if header.palette != nil {
+		indices := image.NewPaletted(bounds, header.palette)
+		per_byte := 8 / header.bpp
+		for y := 0; y < header.height; y++ {
+			pixels := row(y)
+			for x := 0; x < header.width; x++ {
+				b := pixels[x/per_byte]
+				shift := uint(8 - header.bpp*(x%per_byte+1))
+				index := (b >> shift) & (1<<uint(header.bpp) - 1)
+				if int(index) >= len(header.palette) {
+					return nil, fmt.Errorf("BMP pixel at %d,%d uses colour %d, but the palette only has %d", x, y, index, len(header.palette))
+				}
+				indices.SetColorIndex(x, y, index)
+			}
+		}
+		if !isGrayPalette(header.palette) {
+			return indices, nil
+		}
+		gray := image.NewGray(bounds)
+		for i, index := range indices.Pix {
+			gray.Pix[i] = header.palette[index].(color.RGBA).R
+		}
+		return gray, nil
+	}
+
+	// Direct colour
+	has_alpha := header.masks[3] != 0
+	if header.bpp == 32 && header.compression == bmpCompressionRgb {
+		// The fourth byte is reserved, but some writers store alpha there
+		for y := 0; y < header.height && !has_alpha; y++ {
+			pixels := row(y)
+			for x := 0; x < header.width; x++ {
+				if pixels[x*4+3] != 0 {
+					has_alpha = true
+					header.masks[3] = 0xff000000
+					break
+				}
+			}
+		}
+	}
+	img := image.NewNRGBA(bounds)
+	bytes_per_pixel := header.bpp / 8
+	for y := 0; y < header.height; y++ {
+		pixels := row(y)
+		for x := 0; x < header.width; x++ {
+			var value uint32
+			for i := bytes_per_pixel - 1; i >= 0; i-- {
+				value = value<<8 | uint32(pixels[x*bytes_per_pixel+i])
+			}
+			pix := img.Pix[img.PixOffset(x, y):]
+			for channel := 0; channel < 3; channel++ {
+				pix[channel] = bmpChannel(value, header.masks[channel])
+			}
+			pix[3] = 0xff
+			if has_alpha {
+				pix[3] = bmpChannel(value, header.masks[3])
+			}
+		}
+	}
+	return img, nil
+}
+
+func bmpChannel(value uint32, mask uint32) uint8 {
+	/*
+		Extract a channel with a bit mask, scaled to 8 bits.
+	*/
+	if mask == 0 {
+		return 0
+	}
+	shift := bits.TrailingZeros32(mask)
+	width := bits.OnesCount32(mask)
+	v := (value & mask) >> uint(shift)
+	if width == 8 {
+		return uint8(v)
+	}
+	// In 64 bits, as masks can be up to 32 bits wide
+	return uint8(uint64(v) * 255 / (1<<uint64(width) - 1))
+}
+
+func isGrayPalette(palette color.Palette) bool {
+	if len(palette) != 256 {
+		return false
+	}
+	for i, c := range palette {
+		rgba := c.(color.RGBA)
+		if rgba.R != uint8(i) || rgba.G != uint8(i) || rgba.B != uint8(i) {
+			return false
+		}
+	}
+	return true
+}
+
+func EncodeBmp(w io.Writer, img image.Image) error {
+	/*
+		Encode an image as an uncompressed BMP, without changing any pixel values:
+		greyscale as 8-bit with a grey palette, paletted images as 8-bit, opaque
+		images as 24-bit, and images with transparency as 32-bit with an alpha mask.
+	*/
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	var palette color.Palette
+	bpp := 24
+	switch src := img.(type) {
+	case *image.Gray:
+		bpp = 8
+		for i := 0; i < 256; i++ {
+			palette = append(palette, color.RGBA{uint8(i), uint8(i), uint8(i), 0xff})
+		}
+	case *image.Paletted:
+		if len(src.Palette) > 256 {
+			return errors.New("BMP palettes can have at most 256 colours")
+		}
+		bpp = 8
+		palette = src.Palette
+	default:
+		if opaque, ok := img.(interface{ Opaque() bool }); !ok || !opaque.Opaque() {
+			bpp = 32
+		}
+	}
+	info_size := bmpInfoHeaderSize
+	compression := uint32(bmpCompressionRgb)
+	if bpp == 32 {
+		info_size, compression = bmpV4HeaderSize, bmpBitfields
+	}
+	stride := (width*bpp + 31) / 32 * 4
+	pixel_offset := bmpFileHeaderSize + info_size + len(palette)*4
+	file_size := pixel_offset + stride*height
+
+	bw := bufio.NewWriter(w)
+	// File header
+	bw.WriteString("BM")
+	binary.Write(bw, binary.LittleEndian, []uint32{uint32(file_size), 0, uint32(pixel_offset)})
+	// Info header, bottom-up, at 72 DPI
+	binary.Write(bw, binary.LittleEndian, []int32{int32(info_size), int32(width), int32(height)})
+	binary.Write(bw, binary.LittleEndian, []uint16{1, uint16(bpp)})
+	binary.Write(bw, binary.LittleEndian, []uint32{compression, uint32(stride * height), 2835, 2835, uint32(len(palette)), 0})
+	if bpp == 32 {
+		// V4 header: bit masks, the sRGB colour space, and unused endpoints and gammas
+		binary.Write(bw, binary.LittleEndian, []uint32{0x00ff0000, 0x0000ff00, 0x000000ff, 0xff000000, 0x73524742})
+		bw.Write(make([]byte, bmpV4HeaderSize-bmpInfoHeaderSize-20))
+	}
+	for _, c := range palette {
+		r, g, b, _ := c.RGBA()
+		bw.Write([]byte{uint8(b >> 8), uint8(g >> 8), uint8(r >> 8), 0})
+	}
+
+	// Pixels, bottom row first
+	row := make([]byte, stride)
+	for y := height - 1; y >= 0; y-- {
+		for x := 0; x < width; x++ {
+			switch bpp {
+			case 8:
+				if gray, ok := img.(*image.Gray); ok {
+					row[x] = gray.GrayAt(bounds.Min.X+x, bounds.Min.Y+y).Y
+				} else {
+					row[x] = img.(*image.Paletted).ColorIndexAt(bounds.Min.X+x, bounds.Min.Y+y)
+				}
+			default:
+				c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+				pixel := row[x*bpp/8:]
+				pixel[0], pixel[1], pixel[2] = c.B, c.G, c.R
+				if bpp == 32 {
+					pixel[3] = c.A
+				}
+			}
+		}
+		bw.Write(row)
+	}
+	return bw.Flush()
+}
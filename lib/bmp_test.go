@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestBmpRoundTrip(t *testing.T) {
+	bounds := image.Rect(0, 0, 7, 5)
+
+	// 8-bit with a grey palette
+	gray := image.NewGray(bounds)
+	// 8-bit with a full 256 colour palette
+	var palette color.Palette
+	for i := 0; i < 256; i++ {
+		palette = append(palette, color.RGBA{uint8(i), uint8(255 - i), uint8(i * 7), 0xff})
+	}
+	paletted := image.NewPaletted(bounds, palette)
+	// 24-bit
+	opaque := image.NewNRGBA(bounds)
+	// 32-bit, with alpha
+	transparent := image.NewNRGBA(bounds)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			value := uint8(x*37 + y*11)
+			gray.SetGray(x, y, color.Gray{value})
+			paletted.SetColorIndex(x, y, value)
+			opaque.SetNRGBA(x, y, color.NRGBA{value, value / 2, 255 - value, 0xff})
+			transparent.SetNRGBA(x, y, color.NRGBA{value, value / 3, 255 - value, value})
+		}
+	}
+
+	cases := []struct {
+		name string
+		img  image.Image
+		bpp  uint16
+	}{
+		{"8-bit grey", gray, 8},
+		{"8-bit paletted", paletted, 8},
+		{"24-bit", opaque, 24},
+		{"32-bit", transparent, 32},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := EncodeBmp(&buf, c.img); err != nil {
+			t.Fatalf("%s: encode: %v", c.name, err)
+		}
+		data := buf.Bytes()
+		if bpp := uint16(data[28]) | uint16(data[29])<<8; bpp != c.bpp {
+			t.Errorf("%s: encoded as %d-bit, want %d-bit", c.name, bpp, c.bpp)
+		}
+
+		config, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: decode config: %v", c.name, err)
+		}
+		if format != "bmp" || config.Width != bounds.Dx() || config.Height != bounds.Dy() {
+			t.Errorf("%s: config is %s %dx%d, want bmp %dx%d", c.name, format, config.Width, config.Height, bounds.Dx(), bounds.Dy())
+		}
+
+		decoded, err := DecodeBmp(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: decode: %v", c.name, err)
+		}
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				want := color.NRGBAModel.Convert(c.img.At(x, y))
+				got := color.NRGBAModel.Convert(decoded.At(x, y))
+				if got != want {
+					t.Fatalf("%s: pixel %d,%d is %v, want %v", c.name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
@@ -17,6 +17,12 @@ func EmbedBitplane(bitplane_args []string, cover_img image.Image, secret_img ima
 	cover_width, cover_height := cover_bounds.Max.X, cover_bounds.Max.Y
 	new_cover_img := image.NewNRGBA(cover_bounds)
 	draw.Draw(new_cover_img, cover_bounds, cover_img, cover_bounds.Min, draw.Src)
+	var output_img image.Image = new_cover_img
+	cover_pix := new_cover_img.Pix
+	if IsDeepImage(cover_img) {
+		// Embed in the low byte of 16-bit samples
+		output_img, cover_pix = newDeepCanvas(cover_img, 4)
+	}
 
 	// Create secret image copy
 	secret_bounds := secret_img.Bounds()
@@ -57,15 +63,15 @@ func EmbedBitplane(bitplane_args []string, cover_img image.Image, secret_img ima
 				// Change cover image based on above
 				if secret_pixel < 127 {
 					mask := ^(1 << bit_pos)
-					new_cover_img.Pix[cover_index+colour] &= uint8(mask)
+					cover_pix[cover_index+colour] &= uint8(mask)
 				} else {
-					new_cover_img.Pix[cover_index+colour] |= (1 << bit_pos)
+					cover_pix[cover_index+colour] |= (1 << bit_pos)
 				}
 			}
 		}
 	}
 
-	return new_cover_img, nil
+	return output_img, nil
 }
 
 func ExtractBitplane(bitplane_args []string, input_img image.Image) (image.Image, error) {
@@ -80,6 +86,11 @@ func ExtractBitplane(bitplane_args []string, input_img image.Image) (image.Image
 	width, height := bounds.Max.X, bounds.Max.Y
 	new_img := image.NewNRGBA(bounds)
 	draw.Draw(new_img, bounds, input_img, bounds.Min, draw.Src)
+	pix_arr := new_img.Pix
+	if IsDeepImage(input_img) {
+		// Read the low byte of 16-bit samples, as embedded
+		_, pix_arr = newDeepCanvas(input_img, 4)
+	}
 
 	// Check number of values per pixel in image
 	values_per_pixel, err := GetValuesPerPixel(new_img)
@@ -91,7 +102,7 @@ func ExtractBitplane(bitplane_args []string, input_img image.Image) (image.Image
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
 			index := (y*width + x) * values_per_pixel
-			pix := new_img.Pix[index : index+values_per_pixel]
+			pix := pix_arr[index : index+values_per_pixel]
 			// Check that all bit planes for given operations are set to 1
 			has_all_bits := true
 			for _, embed_instruction := range bitplane_operations {
@@ -2,12 +2,17 @@ package lib
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	_ "image/png"
+	"image/draw"
+	"image/png"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -108,6 +113,19 @@ func BitstreamToBytes(bitstream []bool) []byte {
 	return bytes_arr
 }
 
+func BytesToBitstream(data []byte) []bool {
+	/*
+		Convert bytes to a bool bitstream, most significant bit first.
+	*/
+	bitstream := make([]bool, len(data)*8)
+	for idx, val := range data {
+		for i := 0; i < 8; i++ {
+			bitstream[idx*8+i] = val>>uint(7-i)&0x01 == 1
+		}
+	}
+	return bitstream
+}
+
 func BitstringToBytes(bitstring string) []byte {
 	/*
 		Convert a string of bits (i.e., "10101") to bytes array.
@@ -149,6 +167,92 @@ func OpenImage(image_path string) (image.Image, error) {
 	return img, nil
 }
 
+// Formats images can be saved in
+var ImageFormats = []string{"png", "bmp", "pgm", "ppm", "pnm", "pam", "pgm-plain", "ppm-plain"}
+
+func ImageFormatFromPath(image_path string) string {
+	/*
+		Choose an output format from a file extension, defaulting to PNG.
+	*/
+	switch strings.ToLower(filepath.Ext(image_path)) {
+	case ".bmp", ".dib":
+		return "bmp"
+	case ".pgm", ".ppm", ".pnm", ".pam":
+		return strings.ToLower(filepath.Ext(image_path))[1:]
+	}
+	return "png"
+}
+
+func SaveImage(img image.Image, output_path string, format string) error {
+	/*
+		Write image to file in the given format, or one chosen from the file
+		extension if format is empty.
+	*/
+	if format == "" {
+		format = ImageFormatFromPath(output_path)
+	}
+	format = strings.ToLower(format)
+	// Don't lose embedded bits to a format that can't hold the samples
+	if netpbm_img, ok := img.(*NetpbmImage); ok && !isNetpbmFormat(format) {
+		return fmt.Errorf("image has a Netpbm maxval of %d, which only a Netpbm output can keep", netpbm_img.Maxval)
+	}
+	if IsDeepImage(img) && format == "bmp" {
+		return errors.New("image has 16-bit samples, which BMP can't hold. Save it as a PNG or Netpbm image")
+	}
+	var encode func(io.Writer, image.Image) error
+	switch format {
+	case "png":
+		encode = png.Encode
+	case "bmp":
+		encode = EncodeBmp
+	case "pgm", "ppm", "pnm", "pam", "pgm-plain", "ppm-plain":
+		encode = func(w io.Writer, img image.Image) error {
+			return EncodeNetpbm(w, img, format)
+		}
+	default:
+		return fmt.Errorf("unsupported output format '%s'. Must be one of: %s", format, strings.Join(ImageFormats, ", "))
+	}
+	out_file, err := os.Create(output_path)
+	if err != nil {
+		return err
+	}
+	defer out_file.Close()
+	return encode(out_file, img)
+}
+
+func isNetpbmFormat(format string) bool {
+	switch format {
+	case "pgm", "ppm", "pnm", "pam", "pgm-plain", "ppm-plain":
+		return true
+	}
+	return false
+}
+
+func SaveImageLike(img image.Image, output_path string, format string, cover_path string) error {
+	/*
+		Write image to file like SaveImage, but when both the output and the cover
+		are PNGs, store the output like the cover: same colour type, bit depth,
+		interlacing, compression level and ancillary chunks.
+	*/
+	if format == "" {
+		format = ImageFormatFromPath(output_path)
+	}
+	cover_bytes, err := ioutil.ReadFile(cover_path)
+	if err != nil || strings.ToLower(format) != "png" || !bytes.HasPrefix(cover_bytes, PngSignature) {
+		return SaveImage(img, output_path, format)
+	}
+	png_format, err := ReadPngFormat(cover_bytes)
+	if err != nil {
+		return err
+	}
+	out_file, err := os.Create(output_path)
+	if err != nil {
+		return err
+	}
+	defer out_file.Close()
+	return EncodePngLike(out_file, img, png_format)
+}
+
 func GetValuesPerPixel(img image.Image) (int, error) {
 	/*
 		Determine how many ints per pixel.
@@ -156,6 +260,10 @@ func GetValuesPerPixel(img image.Image) (int, error) {
 			  RGBA would have 4: R, G, B and A
 	*/
 	var ints_per_pixel int
+	if _, ok := img.ColorModel().(color.Palette); ok {
+		// Paletted images are worked on as RGBA
+		return 4, nil
+	}
 	switch img.ColorModel() {
 	case color.GrayModel, color.Gray16Model, color.AlphaModel, color.Alpha16Model:
 		ints_per_pixel = 1
@@ -172,6 +280,106 @@ func GetValuesPerPixel(img image.Image) (int, error) {
 	return ints_per_pixel, nil
 }
 
+func newCanvas(img image.Image) (image.Image, []uint8, int, error) {
+	/*
+		Copy an image into a greyscale or NRGBA canvas (depending on its colour
+		model) and return it along with its pixel array and values per pixel.
+		Images with 16-bit samples get a 16-bit canvas, whose pixel array is the
+		low byte of each sample.
+	*/
+	values_per_pixel, err := GetValuesPerPixel(img)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if IsDeepImage(img) {
+		new_img, pix_arr := newDeepCanvas(img, values_per_pixel)
+		return new_img, pix_arr, values_per_pixel, nil
+	}
+	bounds := img.Bounds()
+	if values_per_pixel == 1 {
+		gray_img := image.NewGray(bounds)
+		draw.Draw(gray_img, bounds, img, bounds.Min, draw.Src)
+		return gray_img, gray_img.Pix, values_per_pixel, nil
+	}
+	rgba_img := image.NewNRGBA(bounds)
+	draw.Draw(rgba_img, bounds, img, bounds.Min, draw.Src)
+	return rgba_img, rgba_img.Pix, values_per_pixel, nil
+}
+
+// A 16-bit canvas, split into the high and low byte of each sample, so the low
+// bytes can be embedded in like an 8-bit pixel array
+type deepCanvas struct {
+	rect             image.Rectangle
+	values_per_pixel int
+	high, low        []uint8
+}
+
+func newDeepCanvas(img image.Image, values_per_pixel int) (image.Image, []uint8) {
+	/*
+		Copy a 16-bit image into a deepCanvas with 1 (grey) or 4 (NRGBA) values
+		per pixel, returning it and its low bytes. A NetpbmImage's samples are
+		copied as they are, and the canvas kept in a NetpbmImage with the same maxval.
+	*/
+	src := img
+	netpbm_img, is_netpbm := img.(*NetpbmImage)
+	if is_netpbm {
+		src = netpbm_img.Raw
+	}
+	bounds := img.Bounds()
+	canvas := &deepCanvas{rect: bounds, values_per_pixel: values_per_pixel}
+	size := bounds.Dx() * bounds.Dy() * values_per_pixel
+	canvas.high, canvas.low = make([]uint8, size), make([]uint8, size)
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(src.At(x, y)).(color.NRGBA64)
+			for _, v := range []uint16{c.R, c.G, c.B, c.A}[:values_per_pixel] {
+				canvas.high[i], canvas.low[i] = uint8(v>>8), uint8(v)
+				i++
+			}
+		}
+	}
+	if is_netpbm {
+		return &NetpbmImage{Raw: canvas, Maxval: netpbm_img.Maxval}, canvas.low
+	}
+	return canvas, canvas.low
+}
+
+func (canvas *deepCanvas) ColorModel() color.Model {
+	if canvas.values_per_pixel == 1 {
+		return color.Gray16Model
+	}
+	return color.NRGBA64Model
+}
+
+func (canvas *deepCanvas) Bounds() image.Rectangle {
+	return canvas.rect
+}
+
+func (canvas *deepCanvas) sample(i int) uint16 {
+	return uint16(canvas.high[i])<<8 | uint16(canvas.low[i])
+}
+
+func (canvas *deepCanvas) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(canvas.rect)) {
+		return color.NRGBA64{}
+	}
+	i := ((y-canvas.rect.Min.Y)*canvas.rect.Dx() + (x - canvas.rect.Min.X)) * canvas.values_per_pixel
+	if canvas.values_per_pixel == 1 {
+		return color.Gray16{canvas.sample(i)}
+	}
+	return color.NRGBA64{canvas.sample(i), canvas.sample(i + 1), canvas.sample(i + 2), canvas.sample(i + 3)}
+}
+
+func (canvas *deepCanvas) Opaque() bool {
+	for i := 3; i < len(canvas.low) && canvas.values_per_pixel == 4; i += 4 {
+		if canvas.sample(i) != 0xffff {
+			return false
+		}
+	}
+	return true
+}
+
 func Abs(a int) int {
 	if a < 0 {
 		return a * -1
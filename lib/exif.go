@@ -0,0 +1,253 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+	exifundefined "github.com/dsoprea/go-exif/v3/undefined"
+)
+
+// Innocuous tags a payload can be split across, and the IFD each lives in
+var ExifPayloadTags = map[string]string{
+	"ImageDescription": "IFD",
+	"Artist":           "IFD",
+	"Copyright":        "IFD",
+	"Software":         "IFD",
+	"XPComment":        "IFD",
+	"XPKeywords":       "IFD",
+	"XPSubject":        "IFD",
+	"UserComment":      "IFD/Exif",
+	"MakerNote":        "IFD/Exif",
+	"ImageUniqueID":    "IFD/Exif",
+}
+
+// Prefix of each EXIF payload chunk, followed by its index and the chunk count
+var exifChunkIdentifier = []byte("SGX")
+
+type ExifTag struct {
+	Ifd     string      `json:"ifd"`
+	TagId   uint16      `json:"tag_id"`
+	TagName string      `json:"tag_name"`
+	Type    string      `json:"type"`
+	Count   uint32      `json:"count"`
+	Value   interface{} `json:"value"`
+	Raw     []byte      `json:"-"`
+}
+
+func CollectExif(raw_exif []byte) (exif.IfdIndex, error) {
+	/*
+		Parse raw EXIF data into an index of every IFD.
+	*/
+	im, err := exifcommon.NewIfdMappingWithStandard()
+	if err != nil {
+		return exif.IfdIndex{}, err
+	}
+	ti := exif.NewTagIndex()
+	_, index, err := exif.Collect(im, ti, raw_exif)
+	return index, err
+}
+
+func ExifTree(raw_exif []byte) ([]ExifTag, error) {
+	/*
+		List every tag in every IFD of raw EXIF data (IFD0, the EXIF SubIFD, GPS,
+		Interop, IFD1 and so on), with typed values.
+	*/
+	index, err := CollectExif(raw_exif)
+	if err != nil {
+		return nil, err
+	}
+	var tags []ExifTag
+	for _, ifd := range index.Ifds {
+		for _, entry := range ifd.Entries() {
+			raw_bytes, err := entry.GetRawBytes()
+			if err != nil {
+				raw_bytes = nil
+			}
+			tags = append(tags, ExifTag{
+				Ifd:     ifd.IfdIdentity().String(),
+				TagId:   entry.TagId(),
+				TagName: entry.TagName(),
+				Type:    entry.TagType().String(),
+				Count:   entry.UnitCount(),
+				Value:   exifValue(entry, raw_bytes),
+				Raw:     raw_bytes,
+			})
+		}
+	}
+	return tags, nil
+}
+
+func exifValue(entry *exif.IfdTagEntry, raw_bytes []byte) interface{} {
+	/*
+		Convert a tag's value to something readable and JSON-friendly: text stays
+		as a string, numbers as arrays, rationals as "n/d" and bytes as hex.
+	*/
+	if entry.TagType() == exifcommon.TypeUndefined || entry.TagType() == exifcommon.TypeByte {
+		return hex.EncodeToString(raw_bytes)
+	}
+	value, err := entry.Value()
+	if err != nil {
+		return hex.EncodeToString(raw_bytes)
+	}
+	switch v := value.(type) {
+	case []exifcommon.Rational:
+		rationals := make([]string, len(v))
+		for i, r := range v {
+			rationals[i] = fmt.Sprintf("%d/%d", r.Numerator, r.Denominator)
+		}
+		return rationals
+	case []exifcommon.SignedRational:
+		rationals := make([]string, len(v))
+		for i, r := range v {
+			rationals[i] = fmt.Sprintf("%d/%d", r.Numerator, r.Denominator)
+		}
+		return rationals
+	}
+	return value
+}
+
+func EmbedExifChunks(ib *exif.IfdBuilder, byte_order binary.ByteOrder, tag_names []string, payload []byte) error {
+	/*
+		Split payload evenly across the given tags, each chunk prefixed with its
+		index and the chunk count. Text (ASCII) tags hold their chunk as base64,
+		while binary tags hold it as-is. IFD0 tags can be put in IFD1 instead
+		with an "@IFD1" suffix (i.e., "Artist@IFD1"), so that chunks are spread
+		across both IFDs.
+	*/
+	if len(tag_names) == 0 || len(tag_names) > 255 {
+		return errors.New("between 1 and 255 tags must be given")
+	}
+	// Find where each chunk goes, and check no tag is used twice
+	chunk_tags := make([]string, len(tag_names))
+	tag_ifds := make([]string, len(tag_names))
+	seen := make(map[string]bool)
+	for index, tag_spec := range tag_names {
+		tag_name, ifd_name := tag_spec, ""
+		if at := strings.Index(tag_spec, "@"); at != -1 {
+			tag_name, ifd_name = tag_spec[:at], tag_spec[at+1:]
+		}
+		ifd_path, ok := ExifPayloadTags[tag_name]
+		if !ok {
+			return fmt.Errorf("tag '%s' can't hold a payload chunk. Must be one of: %s", tag_name, exifPayloadTagList())
+		}
+		if ifd_name != "" {
+			if ifd_name != "IFD1" {
+				return fmt.Errorf("invalid IFD '%s' for tag '%s'. Only IFD1 can be given", ifd_name, tag_name)
+			}
+			if ifd_path != "IFD" {
+				return fmt.Errorf("tag '%s' lives in %s, so can't be put in IFD1", tag_name, ifd_path)
+			}
+			ifd_path = "IFD1"
+		}
+		if seen[tag_name+"@"+ifd_path] {
+			return fmt.Errorf("tag '%s' given more than once", tag_spec)
+		}
+		seen[tag_name+"@"+ifd_path] = true
+		chunk_tags[index], tag_ifds[index] = tag_name, ifd_path
+	}
+
+	chunk_size := (len(payload) + len(tag_names) - 1) / len(tag_names)
+	for index, tag_name := range chunk_tags {
+		ifd_path := tag_ifds[index]
+		// Build chunk
+		start, end := index*chunk_size, (index+1)*chunk_size
+		if start > len(payload) {
+			start = len(payload)
+		}
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk := append(append([]byte{}, exifChunkIdentifier...), byte(index), byte(len(tag_names)))
+		chunk = append(chunk, payload[start:end]...)
+
+		// Encode for the tag's type
+		var value interface{}
+		switch tag_name {
+		case "UserComment":
+			value = exifundefined.Tag9286UserComment{EncodingType: exifundefined.TagUndefinedType_9286_UserComment_Encoding_UNDEFINED, EncodingBytes: chunk}
+		case "MakerNote":
+			value = exifundefined.Tag927CMakerNote{MakerNoteBytes: chunk}
+		case "XPComment", "XPKeywords", "XPSubject":
+			value = chunk
+		default:
+			value = base64.StdEncoding.EncodeToString(chunk)
+		}
+
+		var child_ib *exif.IfdBuilder
+		var err error
+		if ifd_path == "IFD1" {
+			child_ib, err = getOrCreateIfd1(ib, byte_order)
+		} else {
+			child_ib, err = exif.GetOrCreateIbFromRootIb(ib, ifd_path)
+		}
+		if err != nil {
+			return err
+		}
+		err = child_ib.SetStandardWithName(tag_name, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ExtractExifChunks(raw_exif []byte) ([]byte, error) {
+	/*
+		Find every chunk written by EmbedExifChunks, in any IFD, and reassemble them.
+	*/
+	tags, err := ExifTree(raw_exif)
+	if err != nil {
+		return nil, err
+	}
+	var chunks [][]byte
+	for _, tag := range tags {
+		data := tag.Raw
+		if tag.Type == "ASCII" {
+			decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimRight(data, "\x00")))
+			if err != nil {
+				continue
+			}
+			data = decoded
+		}
+		// UserComment values start with an 8 byte encoding header
+		position := bytes.Index(data, exifChunkIdentifier)
+		if position == -1 || position > 8 || len(data) < position+len(exifChunkIdentifier)+2 {
+			continue
+		}
+		data = data[position+len(exifChunkIdentifier):]
+		index, count := int(data[0]), int(data[1])
+		if chunks == nil {
+			chunks = make([][]byte, count)
+		}
+		if count != len(chunks) || index >= count {
+			return nil, errors.New("EXIF chunks belong to more than one payload")
+		}
+		chunks[index] = data[2:]
+	}
+	if chunks == nil {
+		return nil, errors.New("no EXIF payload chunks found")
+	}
+	for index, chunk := range chunks {
+		if chunk == nil {
+			return nil, fmt.Errorf("EXIF chunk %d/%d is missing", index+1, len(chunks))
+		}
+	}
+	return bytes.Join(chunks, nil), nil
+}
+
+func exifPayloadTagList() string {
+	var names []string
+	for name := range ExifPayloadTags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Sprint(names)
+}
@@ -0,0 +1,469 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	jpeg "github.com/dsoprea/go-jpeg-image-structure/v2"
+)
+
+const (
+	// Identifier at the start of a JPEG APP2 segment holding part of an ICC profile
+	IccJpegIdentifier = "ICC_PROFILE\x00"
+	// Private tag used for payloads by default
+	IccPayloadTag = "stgo"
+	iccHeaderSize = 128
+)
+
+// Returned by ReadIcc when an image has no ICC profile at all
+var ErrNoIccProfile = errors.New("no ICC profile found")
+
+// Tag signatures registered by the ICC (v2 and v4), which colour management reads
+var iccRegisteredTags = map[string]bool{
+	"A2B0": true, "A2B1": true, "A2B2": true, "B2A0": true, "B2A1": true, "B2A2": true,
+	"B2D0": true, "B2D1": true, "B2D2": true, "B2D3": true, "D2B0": true, "D2B1": true,
+	"D2B2": true, "D2B3": true, "bXYZ": true, "bTRC": true, "bfd ": true, "calt": true,
+	"targ": true, "chad": true, "chrm": true, "cicp": true, "clro": true, "clrt": true,
+	"clot": true, "ciis": true, "cprt": true, "crdi": true, "dmnd": true, "dmdd": true,
+	"devs": true, "gamt": true, "kTRC": true, "gXYZ": true, "gTRC": true, "lumi": true,
+	"meas": true, "meta": true, "mmod": true, "ncol": true, "ncl2": true, "resp": true,
+	"rig0": true, "rig2": true, "pre0": true, "pre1": true, "pre2": true, "desc": true,
+	"pseq": true, "psid": true, "psd0": true, "psd1": true, "psd2": true, "psd3": true,
+	"ps2s": true, "ps2i": true, "rXYZ": true, "rTRC": true, "scrd": true, "scrn": true,
+	"tech": true, "vued": true, "view": true, "wtpt": true,
+}
+
+type IccHeader struct {
+	Size        uint32
+	Cmm         string
+	Version     string
+	Class       string
+	ColourSpace string
+	Pcs         string
+}
+
+type IccTag struct {
+	Signature string
+	Offset    uint32
+	Size      uint32
+	Type      string
+}
+
+func ParseIcc(profile []byte) (IccHeader, []IccTag, error) {
+	/*
+		Read the header and tag table of an ICC profile.
+	*/
+	if len(profile) < iccHeaderSize+4 || string(profile[36:40]) != "acsp" {
+		return IccHeader{}, nil, errors.New("data is not an ICC profile")
+	}
+	header := IccHeader{
+		Size:        binary.BigEndian.Uint32(profile[0:4]),
+		Cmm:         string(profile[4:8]),
+		Version:     fmt.Sprintf("%d.%d.%d", profile[8], profile[9]>>4, profile[9]&0x0f),
+		Class:       string(profile[12:16]),
+		ColourSpace: string(profile[16:20]),
+		Pcs:         string(profile[20:24]),
+	}
+	count := int(binary.BigEndian.Uint32(profile[128:132]))
+	if len(profile) < iccHeaderSize+4+count*12 {
+		return header, nil, errors.New("ICC profile tag table runs past end of profile")
+	}
+	var tags []IccTag
+	for i := 0; i < count; i++ {
+		entry := profile[132+i*12 : 144+i*12]
+		tag := IccTag{
+			Signature: string(entry[0:4]),
+			Offset:    binary.BigEndian.Uint32(entry[4:8]),
+			Size:      binary.BigEndian.Uint32(entry[8:12]),
+		}
+		if int(tag.Offset)+int(tag.Size) > len(profile) || tag.Size < 4 {
+			return header, tags, fmt.Errorf("ICC tag '%s' runs past end of profile", tag.Signature)
+		}
+		if int(tag.Offset) < iccHeaderSize+4+count*12 {
+			return header, tags, fmt.Errorf("ICC tag '%s' starts inside the tag table", tag.Signature)
+		}
+		tag.Type = string(profile[tag.Offset : tag.Offset+4])
+		tags = append(tags, tag)
+	}
+	return header, tags, nil
+}
+
+func GetIccTag(profile []byte, signature string) ([]byte, error) {
+	_, tags, err := ParseIcc(profile)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		if tag.Signature == signature {
+			return profile[tag.Offset : tag.Offset+tag.Size], nil
+		}
+	}
+	return nil, fmt.Errorf("no ICC tag '%s' found", signature)
+}
+
+func SetIccTag(profile []byte, signature string, tag_data []byte) ([]byte, error) {
+	/*
+		Add a tag to an ICC profile, or replace an existing one. Other tags' data is
+		left in place and the new data is added to the end, so only the tag table,
+		profile size and profile ID change. A replaced tag's data is zeroed, or cut
+		off if it was last, except where other tags share it.
+	*/
+	if len(signature) != 4 {
+		return nil, fmt.Errorf("invalid ICC tag signature '%s'. Must be four characters", signature)
+	}
+	_, tags, err := ParseIcc(profile)
+	if err != nil {
+		return nil, err
+	}
+	data_start := iccHeaderSize + 4 + len(tags)*12
+	data := append([]byte{}, profile[data_start:]...)
+
+	var kept []IccTag
+	referenced := make([]bool, len(profile))
+	for _, tag := range tags {
+		if tag.Signature != signature {
+			kept = append(kept, tag)
+			for i := tag.Offset; i < tag.Offset+tag.Size; i++ {
+				referenced[i] = true
+			}
+		}
+	}
+	data_end := len(profile)
+	for _, tag := range tags {
+		if tag.Signature != signature {
+			continue
+		}
+		// Don't leave the old data behind, unless another tag uses it
+		for i := tag.Offset; i < tag.Offset+tag.Size; i++ {
+			if !referenced[i] {
+				data[int(i)-data_start] = 0
+			}
+		}
+		if int(tag.Offset)+int(tag.Size+3)/4*4 >= len(profile) {
+			// The replaced tag was last, so its data can go
+			cut := int(tag.Offset)
+			for i := cut; i < len(profile); i++ {
+				if referenced[i] {
+					cut = i + 1
+				}
+			}
+			if cut < data_end {
+				data_end = cut
+			}
+		}
+	}
+	data = data[:data_end-data_start]
+	shift := uint32((len(kept)+1)*12) - uint32(len(tags)*12)
+
+	var buf bytes.Buffer
+	buf.Write(profile[:iccHeaderSize])
+	binary.Write(&buf, binary.BigEndian, uint32(len(kept)+1))
+	for _, tag := range kept {
+		buf.WriteString(tag.Signature)
+		binary.Write(&buf, binary.BigEndian, tag.Offset+shift)
+		binary.Write(&buf, binary.BigEndian, tag.Size)
+	}
+	new_offset := uint32(buf.Len()+12+len(data)+3) / 4 * 4
+	buf.WriteString(signature)
+	binary.Write(&buf, binary.BigEndian, new_offset)
+	binary.Write(&buf, binary.BigEndian, uint32(len(tag_data)))
+	buf.Write(data)
+	for uint32(buf.Len()) < new_offset {
+		buf.WriteByte(0)
+	}
+	buf.Write(tag_data)
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+
+	new_profile := buf.Bytes()
+	binary.BigEndian.PutUint32(new_profile[0:4], uint32(len(new_profile)))
+	setIccProfileId(new_profile)
+	return new_profile, nil
+}
+
+func setIccProfileId(profile []byte) {
+	/*
+		Recompute the profile ID, an MD5 of the profile with the flags, rendering
+		intent and ID fields zeroed, if the profile has one.
+	*/
+	if bytes.Equal(profile[84:100], make([]byte, 16)) {
+		return
+	}
+	zeroed := append([]byte{}, profile...)
+	copy(zeroed[44:48], make([]byte, 4))
+	copy(zeroed[64:68], make([]byte, 4))
+	copy(zeroed[84:100], make([]byte, 16))
+	digest := md5.Sum(zeroed)
+	copy(profile[84:100], digest[:])
+}
+
+func NewIccDataTag(payload []byte) []byte {
+	/*
+		Wrap payload in a dataType tag, flagged as binary.
+	*/
+	var buf bytes.Buffer
+	buf.WriteString("data")
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+	binary.Write(&buf, binary.BigEndian, uint32(1))
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func ReadIccDataTag(tag_data []byte) []byte {
+	/*
+		Unwrap a dataType tag, or return any other tag type as-is.
+	*/
+	if len(tag_data) >= 12 && string(tag_data[0:4]) == "data" {
+		return tag_data[12:]
+	}
+	return tag_data
+}
+
+func NewIccProfile(gray bool) []byte {
+	/*
+		Build a minimal ICC v2 display profile: sRGB primaries with a 2.2 gamma,
+		or a 2.2 gamma grey profile. Used when an image has no profile to hide in.
+	*/
+	s15 := func(values ...float64) []byte {
+		var buf bytes.Buffer
+		buf.WriteString("XYZ \x00\x00\x00\x00")
+		for _, v := range values {
+			binary.Write(&buf, binary.BigEndian, int32(v*65536+0.5))
+		}
+		return buf.Bytes()
+	}
+	description := "stegogo sRGB"
+	if gray {
+		description = "stegogo Gray"
+	}
+	var desc bytes.Buffer
+	desc.WriteString("desc\x00\x00\x00\x00")
+	binary.Write(&desc, binary.BigEndian, uint32(len(description)+1))
+	desc.WriteString(description)
+	desc.Write(make([]byte, 1+4+4+2+1+67))
+	gamma := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x33\x00\x00")
+
+	tags := []IccTag{{Signature: "desc"}, {Signature: "cprt"}, {Signature: "wtpt"}}
+	tag_data := [][]byte{desc.Bytes(), []byte("text\x00\x00\x00\x00No copyright, use freely\x00"), s15(0.9642, 1.0, 0.8249)}
+	class_space := "mntrRGB XYZ "
+	if gray {
+		class_space = "mntrGRAYXYZ "
+		tags = append(tags, IccTag{Signature: "kTRC"})
+		tag_data = append(tag_data, gamma)
+	} else {
+		tags = append(tags, IccTag{Signature: "rXYZ"}, IccTag{Signature: "gXYZ"}, IccTag{Signature: "bXYZ"}, IccTag{Signature: "rTRC"}, IccTag{Signature: "gTRC"}, IccTag{Signature: "bTRC"})
+		tag_data = append(tag_data, s15(0.4361, 0.2225, 0.0139), s15(0.3851, 0.7169, 0.0971), s15(0.1431, 0.0606, 0.7141), gamma, gamma, gamma)
+	}
+
+	// Lay out tag data after the tag table
+	offset := iccHeaderSize + 4 + len(tags)*12
+	var data bytes.Buffer
+	for index := range tags {
+		tags[index].Offset = uint32(offset + data.Len())
+		tags[index].Size = uint32(len(tag_data[index]))
+		data.Write(tag_data[index])
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+
+	header := make([]byte, iccHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], uint32(offset+data.Len()))
+	header[8], header[9] = 2, 0x10
+	copy(header[12:24], class_space)
+	copy(header[36:40], "acsp")
+	// Rendering intent perceptual, and the D50 illuminant
+	copy(header[68:80], s15(0.9642, 1.0, 0.8249)[8:])
+
+	var buf bytes.Buffer
+	buf.Write(header)
+	binary.Write(&buf, binary.BigEndian, uint32(len(tags)))
+	for _, tag := range tags {
+		buf.WriteString(tag.Signature)
+		binary.Write(&buf, binary.BigEndian, tag.Offset)
+		binary.Write(&buf, binary.BigEndian, tag.Size)
+	}
+	buf.Write(data.Bytes())
+	return buf.Bytes()
+}
+
+func ReadIcc(data []byte) ([]byte, error) {
+	/*
+		Return the ICC profile of a JPEG (joined from its APP2 segments) or PNG
+		(iCCP chunk).
+	*/
+	switch DetectFileType(data) {
+	case "jpeg":
+		sl, err := ParseJpegSegments(data)
+		if err != nil {
+			return nil, err
+		}
+		var chunks [][]byte
+		for _, segment := range sl.Segments() {
+			if segment.MarkerId == JpegMarkerApp0+2 && bytes.HasPrefix(segment.Data, []byte(IccJpegIdentifier)) && len(segment.Data) >= len(IccJpegIdentifier)+2 {
+				chunks = append(chunks, segment.Data[len(IccJpegIdentifier):])
+			}
+		}
+		if len(chunks) == 0 {
+			break
+		}
+		// Chunks carry a 1-based sequence number and the chunk count
+		sort.SliceStable(chunks, func(i, j int) bool { return chunks[i][0] < chunks[j][0] })
+		if int(chunks[0][1]) != len(chunks) {
+			fmt.Printf("WARNING: ICC profile should have %d APP2 segments, but has %d.\n", chunks[0][1], len(chunks))
+		}
+		var profile []byte
+		for _, chunk := range chunks {
+			profile = append(profile, chunk[2:]...)
+		}
+		return profile, nil
+	case "png":
+		chunks, err := ParsePngChunks(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, chunk := range chunks {
+			if chunk.Type != "iCCP" {
+				continue
+			}
+			name_end := bytes.IndexByte(chunk.Data, 0)
+			if name_end == -1 || name_end+2 > len(chunk.Data) {
+				return nil, errors.New("iCCP chunk is truncated")
+			}
+			return zlibDecompress(chunk.Data[name_end+2:])
+		}
+	default:
+		return nil, errors.New("unsupported file type. Must be JPEG or PNG")
+	}
+	return nil, ErrNoIccProfile
+}
+
+func WriteIcc(data []byte, profile []byte) ([]byte, error) {
+	/*
+		Set the ICC profile of a JPEG (split across APP2 segments) or PNG (iCCP
+		chunk, replacing any sRGB chunk, which mustn't be used alongside it).
+	*/
+	switch DetectFileType(data) {
+	case "jpeg":
+		sl, err := ParseJpegSegments(data)
+		if err != nil {
+			return nil, err
+		}
+		var segments []*jpeg.Segment
+		for _, segment := range sl.Segments() {
+			if segment.MarkerId == JpegMarkerApp0+2 && bytes.HasPrefix(segment.Data, []byte(IccJpegIdentifier)) {
+				continue
+			}
+			segments = append(segments, segment)
+		}
+		chunk_size := JpegMaxSegmentData - len(IccJpegIdentifier) - 2
+		count := (len(profile) + chunk_size - 1) / chunk_size
+		if count > 255 {
+			return nil, errors.New("ICC profile is too large to split over JPEG APP2 segments")
+		}
+		var new_segments []*jpeg.Segment
+		for index := 0; index < count; index++ {
+			end := (index + 1) * chunk_size
+			if end > len(profile) {
+				end = len(profile)
+			}
+			segment_data := append([]byte(IccJpegIdentifier), byte(index+1), byte(count))
+			segment_data = append(segment_data, profile[index*chunk_size:end]...)
+			new_segments = append(new_segments, &jpeg.Segment{MarkerId: JpegMarkerApp0 + 2, MarkerName: "APP2", Data: segment_data})
+		}
+		return EncodeJpegSegments(InsertJpegSegments(jpeg.NewSegmentList(segments), new_segments))
+	case "png":
+		chunks, err := ParsePngChunks(data)
+		if err != nil {
+			return nil, err
+		}
+		image_end, err := FindImageEnd(data)
+		if err != nil {
+			return nil, err
+		}
+		name := "ICC Profile"
+		var new_chunks []PngChunk
+		for _, chunk := range chunks {
+			if chunk.Type == "iCCP" {
+				if name_end := bytes.IndexByte(chunk.Data, 0); name_end > 0 {
+					name = string(chunk.Data[:name_end])
+				}
+				continue
+			}
+			if chunk.Type == "sRGB" {
+				fmt.Println("WARNING: Removing sRGB chunk, which can't be used alongside an ICC profile.")
+				continue
+			}
+			new_chunks = append(new_chunks, chunk)
+		}
+		// Compression method 0 (zlib), just after IHDR so it's before PLTE and IDAT
+		iccp_data := append(append([]byte(name), 0, 0), zlibCompress(profile)...)
+		new_chunks = append(new_chunks[:1], append([]PngChunk{{Type: "iCCP", Data: iccp_data}}, new_chunks[1:]...)...)
+		// Keep anything after IEND
+		return append(EncodePngChunks(new_chunks), data[image_end:]...), nil
+	}
+	return nil, errors.New("unsupported file type. Must be JPEG or PNG")
+}
+
+func ValidateIccPayloadTag(signature string) error {
+	/*
+		Check a tag signature is safe to hold a payload: four characters, and not
+		registered, so colour management won't try to read it.
+	*/
+	if len(signature) != 4 {
+		return fmt.Errorf("invalid ICC tag signature '%s'. Must be four characters", signature)
+	}
+	if iccRegisteredTags[signature] {
+		return fmt.Errorf("ICC tag '%s' is a registered tag used for colour management. Use a private signature, i.e., '%s'", signature, IccPayloadTag)
+	}
+	return nil
+}
+
+func jpegComponentCount(data []byte) int {
+	/*
+		Return the number of colour components in JPEG file data, from its start
+		of frame segment, or 0 if it has none.
+	*/
+	sl, err := ParseJpegSegments(data)
+	if err != nil {
+		return 0
+	}
+	for _, segment := range sl.Segments() {
+		// Start of frame markers, which aren't DHT, JPG or DAC
+		marker := segment.MarkerId
+		if marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc && len(segment.Data) > 5 {
+			return int(segment.Data[5])
+		}
+	}
+	return 0
+}
+
+func IsCmykImageData(data []byte) bool {
+	/*
+		Check whether file data holds a four-component (CMYK or YCCK) JPEG, which
+		needs a CMYK ICC profile.
+	*/
+	return DetectFileType(data) == "jpeg" && jpegComponentCount(data) == 4
+}
+
+func IsGrayImageData(data []byte) bool {
+	/*
+		Check whether JPEG or PNG file data holds a single-channel (greyscale) image,
+		which needs a grey rather than an RGB ICC profile.
+	*/
+	switch DetectFileType(data) {
+	case "jpeg":
+		return jpegComponentCount(data) == 1
+	case "png":
+		chunks, err := ParsePngChunks(data)
+		if err == nil && len(chunks) > 0 && chunks[0].Type == "IHDR" && len(chunks[0].Data) >= 10 {
+			return chunks[0].Data[9]&2 == 0 && chunks[0].Data[9] != 3
+		}
+	}
+	return false
+}
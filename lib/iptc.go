@@ -0,0 +1,293 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	iptc "github.com/dsoprea/go-iptc"
+	jpeg "github.com/dsoprea/go-jpeg-image-structure/v2"
+	photoshopinfo "github.com/dsoprea/go-photoshop-info-format"
+)
+
+const (
+	// Identifier at the start of a JPEG APP13 segment holding Photoshop Image Resource Blocks
+	PhotoshopIdentifier = "Photoshop 3.0\x00"
+	// Image resources holding IPTC-IIM data and its MD5 digest
+	IrbIptcResource          = 0x0404
+	IrbCaptionDigestResource = 0x0425
+	// First ID of the range Photoshop leaves for plug-ins, used for payloads by default
+	IrbPayloadResource = 0x0fa0
+)
+
+// Returned by ReadJpegIrb when a JPEG has no Photoshop APP13 segment at all
+var ErrNoIrb = errors.New("no Photoshop APP13 segment found")
+
+// Names of common image resources
+var IrbResourceNames = map[uint16]string{
+	0x03ed: "Resolution info",
+	0x03f3: "Print flags",
+	0x0400: "Layer state",
+	0x0402: "Layers group info",
+	0x0404: "IPTC-NAA record",
+	0x0406: "JPEG quality",
+	0x0408: "Grid and guides",
+	0x0409: "Thumbnail (Photoshop 4.0)",
+	0x040a: "Copyright flag",
+	0x040b: "URL",
+	0x040c: "Thumbnail",
+	0x040d: "Global angle",
+	0x040f: "ICC profile",
+	0x0414: "Document-specific IDs seed",
+	0x041a: "Slices",
+	0x041e: "URL list",
+	0x0421: "Version info",
+	0x0422: "EXIF data 1",
+	0x0424: "XMP metadata",
+	0x0425: "Caption digest",
+	0x0426: "Print scale",
+	0x2710: "Print flags info",
+}
+
+type IptcDataset struct {
+	Record  uint8
+	Dataset uint8
+	Data    []byte
+}
+
+func IrbResourceName(id uint16) string {
+	if name, ok := IrbResourceNames[id]; ok {
+		return name
+	}
+	if id >= 0x07d0 && id <= 0x0bb6 {
+		return "Path information"
+	}
+	if id >= 0x0fa0 && id <= 0x1387 {
+		return "Plug-in resource"
+	}
+	return "Unknown"
+}
+
+func ReadJpegIrb(sl *jpeg.SegmentList) ([]photoshopinfo.Photoshop30InfoRecord, error) {
+	/*
+		Parse the Photoshop Image Resource Blocks of a JPEG. Large IRB data is split
+		across several APP13 segments, so their contents are joined first.
+	*/
+	var body bytes.Buffer
+	for _, segment := range sl.Segments() {
+		if segment.MarkerId == JpegMarkerApp0+13 && bytes.HasPrefix(segment.Data, []byte(PhotoshopIdentifier)) {
+			body.Write(segment.Data[len(PhotoshopIdentifier):])
+		}
+	}
+	if body.Len() == 0 {
+		return nil, ErrNoIrb
+	}
+	var records []photoshopinfo.Photoshop30InfoRecord
+	reader := bytes.NewReader(body.Bytes())
+	for reader.Len() > 0 {
+		record, err := photoshopinfo.ReadPhotoshop30InfoRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func EncodeIrb(records []photoshopinfo.Photoshop30InfoRecord) []byte {
+	/*
+		Join image resources back together, padding names and data to even lengths.
+	*/
+	var buf bytes.Buffer
+	for _, record := range records {
+		record_type := record.RecordType
+		if record_type == "" {
+			record_type = "8BIM"
+		}
+		buf.WriteString(record_type)
+		binary.Write(&buf, binary.BigEndian, record.ImageResourceId)
+		buf.WriteByte(byte(len(record.Name)))
+		buf.WriteString(record.Name)
+		if len(record.Name)%2 == 0 {
+			buf.WriteByte(0)
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(len(record.Data)))
+		buf.Write(record.Data)
+		if len(record.Data)%2 == 1 {
+			buf.WriteByte(0)
+		}
+	}
+	return buf.Bytes()
+}
+
+func WriteJpegIrb(sl *jpeg.SegmentList, records []photoshopinfo.Photoshop30InfoRecord) *jpeg.SegmentList {
+	/*
+		Replace a JPEG's Photoshop APP13 segments, splitting the resources over as
+		many segments as needed.
+	*/
+	var segments []*jpeg.Segment
+	for _, segment := range sl.Segments() {
+		if segment.MarkerId == JpegMarkerApp0+13 && bytes.HasPrefix(segment.Data, []byte(PhotoshopIdentifier)) {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	body := EncodeIrb(records)
+	chunk_size := JpegMaxSegmentData - len(PhotoshopIdentifier)
+	var new_segments []*jpeg.Segment
+	for start := 0; start < len(body); start += chunk_size {
+		end := start + chunk_size
+		if end > len(body) {
+			end = len(body)
+		}
+		data := append([]byte(PhotoshopIdentifier), body[start:end]...)
+		new_segments = append(new_segments, &jpeg.Segment{MarkerId: JpegMarkerApp0 + 13, MarkerName: "APP13", Data: data})
+	}
+	return InsertJpegSegments(jpeg.NewSegmentList(segments), new_segments)
+}
+
+func SetIrbResource(records []photoshopinfo.Photoshop30InfoRecord, id uint16, data []byte) []photoshopinfo.Photoshop30InfoRecord {
+	/*
+		Replace the data of a resource, or add it if missing.
+	*/
+	for index, record := range records {
+		if record.ImageResourceId == id {
+			records[index].Data = data
+			return records
+		}
+	}
+	return append(records, photoshopinfo.Photoshop30InfoRecord{RecordType: "8BIM", ImageResourceId: id, Data: data})
+}
+
+func GetIrbResource(records []photoshopinfo.Photoshop30InfoRecord, id uint16) ([]byte, error) {
+	for _, record := range records {
+		if record.ImageResourceId == id {
+			return record.Data, nil
+		}
+	}
+	return nil, fmt.Errorf("no image resource 0x%04x found", id)
+}
+
+func ParseIptc(data []byte) ([]IptcDataset, error) {
+	/*
+		Parse IPTC-IIM data into datasets, ordered by record and dataset number.
+	*/
+	tags, err := iptc.ParseStream(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var keys []iptc.StreamTagKey
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].RecordNumber != keys[j].RecordNumber {
+			return keys[i].RecordNumber < keys[j].RecordNumber
+		}
+		return keys[i].DatasetNumber < keys[j].DatasetNumber
+	})
+	var datasets []IptcDataset
+	for _, key := range keys {
+		for _, value := range tags[key] {
+			datasets = append(datasets, IptcDataset{Record: key.RecordNumber, Dataset: key.DatasetNumber, Data: value})
+		}
+	}
+	return datasets, nil
+}
+
+func EncodeIptc(datasets []IptcDataset) []byte {
+	/*
+		Join datasets back into IPTC-IIM data. Values of 32 KiB or more use the
+		extended length form.
+	*/
+	var buf bytes.Buffer
+	for _, dataset := range datasets {
+		buf.Write([]byte{0x1c, dataset.Record, dataset.Dataset})
+		if len(dataset.Data) < 0x8000 {
+			binary.Write(&buf, binary.BigEndian, uint16(len(dataset.Data)))
+		} else {
+			binary.Write(&buf, binary.BigEndian, uint16(0x8004))
+			binary.Write(&buf, binary.BigEndian, uint32(len(dataset.Data)))
+		}
+		buf.Write(dataset.Data)
+	}
+	return buf.Bytes()
+}
+
+func SetIptcDataset(datasets []IptcDataset, record uint8, dataset uint8, data []byte) []IptcDataset {
+	/*
+		Replace every value of a dataset with data, keeping datasets in order. The
+		application record must start with a Record Version, so one is added if needed.
+	*/
+	if record == 2 && dataset != 0 {
+		has_version := false
+		for _, existing := range datasets {
+			has_version = has_version || (existing.Record == 2 && existing.Dataset == 0)
+		}
+		if !has_version {
+			datasets = SetIptcDataset(datasets, 2, 0, []byte{0, 4})
+		}
+	}
+	var updated []IptcDataset
+	inserted := false
+	for _, existing := range datasets {
+		if existing.Record == record && existing.Dataset == dataset {
+			continue
+		}
+		if !inserted && (existing.Record > record || (existing.Record == record && existing.Dataset > dataset)) {
+			updated = append(updated, IptcDataset{Record: record, Dataset: dataset, Data: data})
+			inserted = true
+		}
+		updated = append(updated, existing)
+	}
+	if !inserted {
+		updated = append(updated, IptcDataset{Record: record, Dataset: dataset, Data: data})
+	}
+	return updated
+}
+
+func SetJpegIptc(records []photoshopinfo.Photoshop30InfoRecord, datasets []IptcDataset) []photoshopinfo.Photoshop30InfoRecord {
+	/*
+		Store IPTC datasets in the IRB, updating the caption digest if there is one
+		so that Photoshop doesn't flag the IPTC data as modified by another program.
+	*/
+	data := EncodeIptc(datasets)
+	records = SetIrbResource(records, IrbIptcResource, data)
+	if _, err := GetIrbResource(records, IrbCaptionDigestResource); err == nil {
+		digest := md5.Sum(data)
+		records = SetIrbResource(records, IrbCaptionDigestResource, digest[:])
+	}
+	return records
+}
+
+func ParseIptcDataset(name string) (uint8, uint8, error) {
+	/*
+		Convert "record:dataset", e.g. "2:202", to its numbers.
+	*/
+	parts := strings.Split(name, ":")
+	if len(parts) == 2 {
+		record, err1 := strconv.ParseUint(parts[0], 10, 8)
+		dataset, err2 := strconv.ParseUint(parts[1], 10, 8)
+		if err1 == nil && err2 == nil {
+			return uint8(record), uint8(dataset), nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid IPTC dataset '%s'. Must be 'record:dataset', e.g. '2:202'", name)
+}
+
+func IptcDatasetName(record uint8, dataset uint8) string {
+	info, err := iptc.GetTagInfo(int(record), int(dataset))
+	if err != nil {
+		return "Unknown"
+	}
+	return info.Description
+}
@@ -0,0 +1,799 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// JPEG markers used when reading and writing DCT coefficients
+const (
+	jpegMarkerSof0 = 0xc0
+	jpegMarkerSof1 = 0xc1
+	jpegMarkerSof2 = 0xc2
+	jpegMarkerDht  = 0xc4
+	jpegMarkerRst0 = 0xd0
+	jpegMarkerRst7 = 0xd7
+	jpegMarkerSoi  = 0xd8
+	jpegMarkerEoi  = 0xd9
+	jpegMarkerSos  = 0xda
+	jpegMarkerDqt  = 0xdb
+	jpegMarkerDri  = 0xdd
+)
+
+// A colour component of a JPEG, with its quantised DCT coefficients. Blocks cover
+// the image padded to whole MCUs, row by row, each in zigzag order.
+type JpegComponent struct {
+	Id         uint8
+	H, V       int
+	QuantTable uint8
+	BlocksWide int
+	BlocksHigh int
+	Blocks     [][64]int16
+	// Blocks actually covering the component, rather than MCU padding
+	coded_wide, coded_high int
+}
+
+// The quantised DCT coefficients of a JPEG, and what's needed to write it back
+type JpegCoefficients struct {
+	Width, Height int
+	Components    []*JpegComponent
+	// Raw DQT table definitions, by table number
+	QuantTables [4][]byte
+	// APPn and COM segments (marker and data), kept as they are
+	Segments    [][]byte
+	Progressive bool
+	mcus_wide   int
+	mcus_high   int
+}
+
+type jpegHuffmanTable struct {
+	maxcode [18]int
+	mincode [17]int
+	valptr  [17]int
+	values  []byte
+}
+
+type jpegScanComponent struct {
+	component *JpegComponent
+	dc, ac    *jpegHuffmanTable
+	pred      int
+}
+
+type jpegDecoder struct {
+	data       []byte
+	pos        int
+	bits       uint32
+	bit_count  int
+	marker_hit bool
+	eobrun     int
+}
+
+func newJpegHuffmanTable(counts []byte, values []byte) *jpegHuffmanTable {
+	/*
+		Build decoding tables from the code length counts and values of a DHT.
+	*/
+	table := &jpegHuffmanTable{values: values}
+	code, k := 0, 0
+	for length := 1; length <= 16; length++ {
+		table.valptr[length] = k
+		table.mincode[length] = code
+		code += int(counts[length-1])
+		k += int(counts[length-1])
+		table.maxcode[length] = -1
+		if counts[length-1] > 0 {
+			table.maxcode[length] = code - 1
+		}
+		code <<= 1
+	}
+	return table
+}
+
+func (d *jpegDecoder) readBit() int {
+	if d.bit_count == 0 {
+		b := byte(0)
+		if d.pos < len(d.data) && !d.marker_hit {
+			b = d.data[d.pos]
+			if b == 0xff && d.pos+1 < len(d.data) && d.data[d.pos+1] != 0 {
+				// A marker ends the entropy-coded data, so pad with zeros
+				d.marker_hit, b = true, 0
+			} else if b == 0xff {
+				d.pos += 2
+			} else {
+				d.pos++
+			}
+		}
+		d.bits, d.bit_count = uint32(b), 8
+	}
+	d.bit_count--
+	return int(d.bits>>uint(d.bit_count)) & 1
+}
+
+func (d *jpegDecoder) receive(size int) int {
+	value := 0
+	for i := 0; i < size; i++ {
+		value = value<<1 | d.readBit()
+	}
+	return value
+}
+
+func jpegExtend(value int, size int) int {
+	if size > 0 && value < 1<<uint(size-1) {
+		return value - (1 << uint(size)) + 1
+	}
+	return value
+}
+
+func (d *jpegDecoder) decodeHuffman(table *jpegHuffmanTable) (int, error) {
+	if table == nil {
+		return 0, errors.New("JPEG scan uses an undefined Huffman table")
+	}
+	code := 0
+	for length := 1; length <= 16; length++ {
+		code = code<<1 | d.readBit()
+		if code <= table.maxcode[length] {
+			return int(table.values[table.valptr[length]+code-table.mincode[length]]), nil
+		}
+	}
+	return 0, errors.New("invalid Huffman code in JPEG scan")
+}
+
+func (d *jpegDecoder) restart() {
+	/*
+		Skip past an RSTn marker, resetting the bit reader.
+	*/
+	d.bit_count, d.marker_hit, d.eobrun = 0, false, 0
+	for d.pos+1 < len(d.data) {
+		if d.data[d.pos] == 0xff && d.data[d.pos+1] >= jpegMarkerRst0 && d.data[d.pos+1] <= jpegMarkerRst7 {
+			d.pos += 2
+			return
+		}
+		d.pos++
+	}
+}
+
+func (d *jpegDecoder) decodeBlock(block *[64]int16, sc *jpegScanComponent, ss int, se int, ah int, al int, progressive bool) error {
+	/*
+		Decode one block of a scan: all coefficients of a sequential JPEG, or the
+		spectral band and bits of a progressive scan.
+	*/
+	if !progressive {
+		ss, se, ah, al = 0, 63, 0, 0
+	}
+	k := ss
+	if ss == 0 {
+		// DC coefficient
+		if ah == 0 {
+			size, err := d.decodeHuffman(sc.dc)
+			if err != nil {
+				return err
+			}
+			sc.pred += jpegExtend(d.receive(size), size)
+			block[0] = int16(sc.pred << uint(al))
+		} else if d.readBit() == 1 {
+			block[0] |= int16(1 << uint(al))
+		}
+		if !progressive {
+			k = 1
+		} else {
+			return nil
+		}
+	}
+
+	// AC coefficients, first pass
+	if ah == 0 {
+		if d.eobrun > 0 {
+			d.eobrun--
+			return nil
+		}
+		for ; k <= se; k++ {
+			rs, err := d.decodeHuffman(sc.ac)
+			if err != nil {
+				return err
+			}
+			run, size := rs>>4, rs&15
+			if size == 0 {
+				if run == 15 {
+					k += 15
+					continue
+				}
+				if progressive {
+					d.eobrun = (1 << uint(run)) - 1
+					if run > 0 {
+						d.eobrun += d.receive(run)
+					}
+				}
+				break
+			}
+			k += run
+			if k > 63 {
+				return errors.New("JPEG block has too many coefficients")
+			}
+			block[k] = int16(jpegExtend(d.receive(size), size) << uint(al))
+		}
+		return nil
+	}
+
+	// AC coefficients, refinement pass
+	p1, m1 := int16(1<<uint(al)), int16(-1<<uint(al))
+	refine := func(coefficient *int16) {
+		if d.readBit() == 1 && *coefficient&p1 == 0 {
+			if *coefficient >= 0 {
+				*coefficient += p1
+			} else {
+				*coefficient += m1
+			}
+		}
+	}
+	if d.eobrun == 0 {
+		for ; k <= se; k++ {
+			rs, err := d.decodeHuffman(sc.ac)
+			if err != nil {
+				return err
+			}
+			run, size := rs>>4, rs&15
+			value := int16(0)
+			if size != 0 {
+				value = m1
+				if d.readBit() == 1 {
+					value = p1
+				}
+			} else if run != 15 {
+				d.eobrun = 1 << uint(run)
+				if run > 0 {
+					d.eobrun += d.receive(run)
+				}
+				break
+			}
+			for ; k <= se; k++ {
+				if block[k] != 0 {
+					refine(&block[k])
+				} else {
+					if run == 0 {
+						break
+					}
+					run--
+				}
+			}
+			if value != 0 && k <= se {
+				block[k] = value
+			}
+		}
+	}
+	if d.eobrun > 0 {
+		for ; k <= se; k++ {
+			if block[k] != 0 {
+				refine(&block[k])
+			}
+		}
+		d.eobrun--
+	}
+	return nil
+}
+
+func ReadJpegCoefficients(data []byte) (*JpegCoefficients, error) {
+	/*
+		Read the quantised DCT coefficients of a baseline, extended or progressive
+		Huffman-coded 8-bit JPEG, without decoding it to pixels.
+	*/
+	if len(data) < 4 || data[0] != 0xff || data[1] != jpegMarkerSoi {
+		return nil, errors.New("file is not a JPEG")
+	}
+	jc := &JpegCoefficients{}
+	var dc_tables, ac_tables [4]*jpegHuffmanTable
+	restart_interval := 0
+	frame_found := false
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xff {
+			return nil, fmt.Errorf("expected a JPEG marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			// Fill byte
+			pos++
+			continue
+		}
+		if marker == jpegMarkerEoi {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, fmt.Errorf("JPEG segment at offset %d runs past end of file", pos)
+		}
+		segment := data[pos+4 : pos+2+length]
+		pos += 2 + length
+
+		switch {
+		case marker == jpegMarkerDqt:
+			for len(segment) > 0 {
+				size := 65
+				if segment[0]>>4 == 1 {
+					size = 129
+				}
+				if len(segment) < size || segment[0]&15 > 3 {
+					return nil, errors.New("invalid JPEG quantisation table")
+				}
+				jc.QuantTables[segment[0]&15] = segment[:size]
+				segment = segment[size:]
+			}
+		case marker == jpegMarkerDht:
+			for len(segment) >= 17 {
+				class, id := segment[0]>>4, segment[0]&15
+				total := 0
+				for _, count := range segment[1:17] {
+					total += int(count)
+				}
+				if len(segment) < 17+total || id > 3 {
+					return nil, errors.New("invalid JPEG Huffman table")
+				}
+				table := newJpegHuffmanTable(segment[1:17], segment[17:17+total])
+				if class == 0 {
+					dc_tables[id] = table
+				} else {
+					ac_tables[id] = table
+				}
+				segment = segment[17+total:]
+			}
+		case marker == jpegMarkerDri:
+			if len(segment) >= 2 {
+				restart_interval = int(binary.BigEndian.Uint16(segment))
+			}
+		case marker == jpegMarkerSof0 || marker == jpegMarkerSof1 || marker == jpegMarkerSof2:
+			if err := jc.readFrame(segment, marker == jpegMarkerSof2); err != nil {
+				return nil, err
+			}
+			frame_found = true
+		case marker >= jpegMarkerSof0 && marker <= 0xcf && marker != jpegMarkerDht && marker != 0xc8 && marker != 0xcc:
+			return nil, fmt.Errorf("unsupported JPEG type (SOF%d). Only Huffman-coded baseline, extended and progressive JPEGs are supported", marker-jpegMarkerSof0)
+		case marker == jpegMarkerSos:
+			if !frame_found {
+				return nil, errors.New("JPEG scan comes before the frame header")
+			}
+			end, err := jc.readScan(data, pos, segment, dc_tables, ac_tables, restart_interval)
+			if err != nil {
+				return nil, err
+			}
+			pos = end
+		case (marker >= JpegMarkerApp0 && marker <= JpegMarkerApp0+15) || marker == JpegMarkerCom:
+			if !frame_found {
+				jc.Segments = append(jc.Segments, append([]byte{marker}, segment...))
+			}
+		}
+	}
+	if !frame_found {
+		return nil, errors.New("JPEG has no frame header")
+	}
+	return jc, nil
+}
+
+func OpenJpegCoefficients(image_path string) (*JpegCoefficients, error) {
+	data, err := ioutil.ReadFile(image_path)
+	if err != nil {
+		return nil, err
+	}
+	return ReadJpegCoefficients(data)
+}
+
+func (jc *JpegCoefficients) readFrame(segment []byte, progressive bool) error {
+	/*
+		Parse a SOF segment, allocating blocks for every component.
+	*/
+	if len(segment) < 6 {
+		return errors.New("invalid JPEG frame header")
+	}
+	if segment[0] != 8 {
+		return fmt.Errorf("unsupported JPEG precision of %d bits. Only 8-bit JPEGs are supported", segment[0])
+	}
+	jc.Progressive = progressive
+	jc.Height = int(binary.BigEndian.Uint16(segment[1:]))
+	jc.Width = int(binary.BigEndian.Uint16(segment[3:]))
+	count := int(segment[5])
+	if jc.Width == 0 || jc.Height == 0 || count == 0 || len(segment) < 6+count*3 {
+		return errors.New("invalid JPEG frame header")
+	}
+	h_max, v_max := 1, 1
+	for i := 0; i < count; i++ {
+		spec := segment[6+i*3:]
+		c := &JpegComponent{Id: spec[0], H: int(spec[1] >> 4), V: int(spec[1] & 15), QuantTable: spec[2] & 3}
+		if c.H < 1 || c.H > 4 || c.V < 1 || c.V > 4 {
+			return errors.New("invalid JPEG sampling factors")
+		}
+		if c.H > h_max {
+			h_max = c.H
+		}
+		if c.V > v_max {
+			v_max = c.V
+		}
+		jc.Components = append(jc.Components, c)
+	}
+	jc.mcus_wide = (jc.Width + 8*h_max - 1) / (8 * h_max)
+	jc.mcus_high = (jc.Height + 8*v_max - 1) / (8 * v_max)
+	for _, c := range jc.Components {
+		c.BlocksWide, c.BlocksHigh = jc.mcus_wide*c.H, jc.mcus_high*c.V
+		c.coded_wide = ((jc.Width*c.H+h_max-1)/h_max + 7) / 8
+		c.coded_high = ((jc.Height*c.V+v_max-1)/v_max + 7) / 8
+		c.Blocks = make([][64]int16, c.BlocksWide*c.BlocksHigh)
+	}
+	return nil
+}
+
+func (jc *JpegCoefficients) readScan(data []byte, pos int, header []byte, dc_tables [4]*jpegHuffmanTable, ac_tables [4]*jpegHuffmanTable, restart_interval int) (int, error) {
+	/*
+		Decode the entropy-coded data of a scan into the coefficients, returning the
+		offset of the marker after it.
+	*/
+	if len(header) < 1 || len(header) < 1+int(header[0])*2+3 {
+		return 0, errors.New("invalid JPEG scan header")
+	}
+	count := int(header[0])
+	var scan []*jpegScanComponent
+	for i := 0; i < count; i++ {
+		id, tables := header[1+i*2], header[2+i*2]
+		var component *JpegComponent
+		for _, c := range jc.Components {
+			if c.Id == id {
+				component = c
+			}
+		}
+		if component == nil {
+			return 0, fmt.Errorf("JPEG scan uses unknown component %d", id)
+		}
+		scan = append(scan, &jpegScanComponent{component: component, dc: dc_tables[tables>>4&3], ac: ac_tables[tables&3]})
+	}
+	spectral := header[1+count*2:]
+	ss, se, ah, al := int(spectral[0]), int(spectral[1]), int(spectral[2]>>4), int(spectral[2]&15)
+	if ss > se || se > 63 {
+		return 0, errors.New("invalid JPEG scan spectral selection")
+	}
+
+	d := &jpegDecoder{data: data, pos: pos}
+	unit := 0
+	next_unit := func() {
+		if restart_interval > 0 && unit > 0 && unit%restart_interval == 0 {
+			d.restart()
+			for _, sc := range scan {
+				sc.pred = 0
+			}
+		}
+		unit++
+	}
+	if count == 1 {
+		// Non-interleaved: every block of the component, without MCU padding
+		sc := scan[0]
+		c := sc.component
+		for by := 0; by < c.coded_high; by++ {
+			for bx := 0; bx < c.coded_wide; bx++ {
+				next_unit()
+				if err := d.decodeBlock(&c.Blocks[by*c.BlocksWide+bx], sc, ss, se, ah, al, jc.Progressive); err != nil {
+					return 0, err
+				}
+			}
+		}
+	} else {
+		for my := 0; my < jc.mcus_high; my++ {
+			for mx := 0; mx < jc.mcus_wide; mx++ {
+				next_unit()
+				for _, sc := range scan {
+					c := sc.component
+					for v := 0; v < c.V; v++ {
+						for h := 0; h < c.H; h++ {
+							if err := d.decodeBlock(&c.Blocks[(my*c.V+v)*c.BlocksWide+mx*c.H+h], sc, ss, se, ah, al, jc.Progressive); err != nil {
+								return 0, err
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Find the next marker that isn't a restart
+	end := d.pos
+	for end+1 < len(data) {
+		if data[end] == 0xff && data[end+1] != 0 && data[end+1] != 0xff && (data[end+1] < jpegMarkerRst0 || data[end+1] > jpegMarkerRst7) {
+			return end, nil
+		}
+		end++
+	}
+	return len(data), nil
+}
+
+func (jc *JpegCoefficients) scanBlocks(fn func(component int, block *[64]int16)) {
+	/*
+		Walk the blocks in the order they are written: MCU by MCU for images with
+		several components, otherwise row by row without padding.
+	*/
+	if len(jc.Components) == 1 {
+		c := jc.Components[0]
+		for by := 0; by < c.coded_high; by++ {
+			for bx := 0; bx < c.coded_wide; bx++ {
+				fn(0, &c.Blocks[by*c.BlocksWide+bx])
+			}
+		}
+		return
+	}
+	for my := 0; my < jc.mcus_high; my++ {
+		for mx := 0; mx < jc.mcus_wide; mx++ {
+			for i, c := range jc.Components {
+				for v := 0; v < c.V; v++ {
+					for h := 0; h < c.H; h++ {
+						fn(i, &c.Blocks[(my*c.V+v)*c.BlocksWide+mx*c.H+h])
+					}
+				}
+			}
+		}
+	}
+}
+
+type jpegBitWriter struct {
+	buf       bytes.Buffer
+	bits      uint32
+	bit_count int
+}
+
+func (w *jpegBitWriter) writeBits(value int, size int) {
+	for i := size - 1; i >= 0; i-- {
+		w.bits = w.bits<<1 | uint32(value>>uint(i))&1
+		w.bit_count++
+		if w.bit_count == 8 {
+			w.buf.WriteByte(byte(w.bits))
+			if byte(w.bits) == 0xff {
+				// Byte stuffing
+				w.buf.WriteByte(0)
+			}
+			w.bits, w.bit_count = 0, 0
+		}
+	}
+}
+
+func (w *jpegBitWriter) flush() {
+	// Pad the last byte with ones
+	if w.bit_count > 0 {
+		w.writeBits(0xff, 8-w.bit_count)
+	}
+}
+
+func jpegMagnitude(value int) (int, int) {
+	/*
+		Size category and extra bits of a coefficient or DC difference.
+	*/
+	magnitude := value
+	if magnitude < 0 {
+		magnitude = -magnitude
+	}
+	size := 0
+	for magnitude > 0 {
+		size++
+		magnitude >>= 1
+	}
+	if value < 0 {
+		value += (1 << uint(size)) - 1
+	}
+	return size, value
+}
+
+func (jc *JpegCoefficients) encodeSymbols(emit func(class int, table int, symbol int, extra int, extra_size int)) {
+	/*
+		Produce the Huffman symbols and extra bits of a single sequential scan.
+		Luminance (the first component) uses table 0 and the others table 1.
+	*/
+	preds := make([]int, len(jc.Components))
+	jc.scanBlocks(func(component int, block *[64]int16) {
+		table := 0
+		if component > 0 {
+			table = 1
+		}
+		size, extra := jpegMagnitude(int(block[0]) - preds[component])
+		preds[component] = int(block[0])
+		emit(0, table, size, extra, size)
+		run := 0
+		for k := 1; k < 64; k++ {
+			if block[k] == 0 {
+				run++
+				continue
+			}
+			for run > 15 {
+				emit(1, table, 0xf0, 0, 0)
+				run -= 16
+			}
+			size, extra := jpegMagnitude(int(block[k]))
+			emit(1, table, run<<4|size, extra, size)
+			run = 0
+		}
+		if run > 0 {
+			emit(1, table, 0x00, 0, 0)
+		}
+	})
+}
+
+func jpegOptimalTable(frequencies []int) ([]byte, []byte) {
+	/*
+		Build a Huffman table of code lengths of at most 16 bits from symbol
+		frequencies, following Annex K.2 of the JPEG standard. Returns the count of
+		codes of each length and the symbols in code order.
+	*/
+	freq := make([]int, 257)
+	copy(freq, frequencies)
+	// Reserve one code point so no code is all ones
+	freq[256] = 1
+	code_size := make([]int, 257)
+	others := make([]int, 257)
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		c1, c2 := -1, -1
+		for i := 0; i < 257; i++ {
+			if freq[i] > 0 && (c1 < 0 || freq[i] <= freq[c1]) {
+				c1 = i
+			}
+		}
+		for i := 0; i < 257; i++ {
+			if freq[i] > 0 && i != c1 && (c2 < 0 || freq[i] <= freq[c2]) {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		freq[c1] += freq[c2]
+		freq[c2] = 0
+		code_size[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			code_size[c1]++
+		}
+		others[c1] = c2
+		code_size[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			code_size[c2]++
+		}
+	}
+	bits := make([]int, 33)
+	for _, size := range code_size {
+		if size > 0 {
+			bits[size]++
+		}
+	}
+	// Limit code lengths to 16 bits
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	// Remove the reserved code point
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+
+	counts := make([]byte, 16)
+	for length := 1; length <= 16; length++ {
+		counts[length-1] = byte(bits[length])
+	}
+	var values []byte
+	for size := 1; size <= 32; size++ {
+		for symbol := 0; symbol < 256; symbol++ {
+			if code_size[symbol] == size {
+				values = append(values, byte(symbol))
+			}
+		}
+	}
+	return counts, values
+}
+
+func jpegHuffmanCodes(counts []byte, values []byte) ([256]int, [256]int) {
+	var codes, sizes [256]int
+	code, k := 0, 0
+	for length := 1; length <= 16; length++ {
+		for i := 0; i < int(counts[length-1]); i++ {
+			codes[values[k]], sizes[values[k]] = code, length
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return codes, sizes
+}
+
+func writeJpegSegment(buf *bytes.Buffer, marker byte, data []byte) {
+	buf.Write([]byte{0xff, marker})
+	binary.Write(buf, binary.BigEndian, uint16(len(data)+2))
+	buf.Write(data)
+}
+
+func WriteJpegCoefficients(jc *JpegCoefficients) ([]byte, error) {
+	/*
+		Write coefficients as a sequential JPEG with a single interleaved scan, the
+		original quantisation tables, APPn and COM segments, and Huffman tables
+		optimised for the coefficients.
+	*/
+	tables := 1
+	if len(jc.Components) > 1 {
+		tables = 2
+	}
+
+	// Count symbols and build Huffman tables
+	frequencies := make([][]int, 4)
+	for i := range frequencies {
+		frequencies[i] = make([]int, 256)
+	}
+	jc.encodeSymbols(func(class int, table int, symbol int, extra int, extra_size int) {
+		frequencies[class*2+table][symbol]++
+	})
+	var dht bytes.Buffer
+	var codes, sizes [4][256]int
+	for class := 0; class < 2; class++ {
+		for table := 0; table < tables; table++ {
+			counts, values := jpegOptimalTable(frequencies[class*2+table])
+			codes[class*2+table], sizes[class*2+table] = jpegHuffmanCodes(counts, values)
+			dht.WriteByte(byte(class<<4 | table))
+			dht.Write(counts)
+			dht.Write(values)
+		}
+	}
+
+	// Headers
+	var out bytes.Buffer
+	out.Write([]byte{0xff, jpegMarkerSoi})
+	for _, segment := range jc.Segments {
+		writeJpegSegment(&out, segment[0], segment[1:])
+	}
+	sof_marker := byte(jpegMarkerSof0)
+	var dqt bytes.Buffer
+	written := make(map[uint8]bool)
+	for _, c := range jc.Components {
+		table := jc.QuantTables[c.QuantTable]
+		if table == nil {
+			return nil, fmt.Errorf("JPEG component %d uses an undefined quantisation table", c.Id)
+		}
+		if !written[c.QuantTable] {
+			dqt.Write(table)
+			written[c.QuantTable] = true
+		}
+		if table[0]>>4 == 1 {
+			// 16-bit quantisation tables aren't allowed in baseline JPEGs
+			sof_marker = jpegMarkerSof1
+		}
+	}
+	writeJpegSegment(&out, jpegMarkerDqt, dqt.Bytes())
+	sof := []byte{8, byte(jc.Height >> 8), byte(jc.Height), byte(jc.Width >> 8), byte(jc.Width), byte(len(jc.Components))}
+	for _, c := range jc.Components {
+		sof = append(sof, c.Id, byte(c.H<<4|c.V), c.QuantTable)
+	}
+	writeJpegSegment(&out, sof_marker, sof)
+	writeJpegSegment(&out, jpegMarkerDht, dht.Bytes())
+	sos := []byte{byte(len(jc.Components))}
+	for i, c := range jc.Components {
+		table := byte(0)
+		if i > 0 {
+			table = 1
+		}
+		sos = append(sos, c.Id, table<<4|table)
+	}
+	sos = append(sos, 0, 63, 0)
+	writeJpegSegment(&out, jpegMarkerSos, sos)
+
+	// Entropy-coded data
+	w := &jpegBitWriter{}
+	jc.encodeSymbols(func(class int, table int, symbol int, extra int, extra_size int) {
+		w.writeBits(codes[class*2+table][symbol], sizes[class*2+table][symbol])
+		w.writeBits(extra, extra_size)
+	})
+	w.flush()
+	out.Write(w.buf.Bytes())
+	out.Write([]byte{0xff, jpegMarkerEoi})
+	return out.Bytes(), nil
+}
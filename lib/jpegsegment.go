@@ -0,0 +1,230 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	jpeg "github.com/dsoprea/go-jpeg-image-structure/v2"
+)
+
+const (
+	JpegMarkerCom  byte = 0xfe
+	JpegMarkerApp0 byte = 0xe0
+	// Largest payload a length-prefixed segment can carry
+	JpegMaxSegmentData = 65533
+)
+
+// Prefix of segments written by stegogo, followed by a uint16 index and count
+var jpegChunkIdentifier = []byte("STEGOGO\x00")
+
+func ParseJpegSegments(data []byte) (*jpeg.SegmentList, error) {
+	/*
+		Split JPEG file data into its segments.
+	*/
+	jmp := jpeg.NewJpegMediaParser()
+	intfc, err := jmp.ParseBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	sl, ok := intfc.(*jpeg.SegmentList)
+	if !ok {
+		return nil, errors.New("file is not a JPEG")
+	}
+	return sl, nil
+}
+
+func OpenJpegSegments(image_path string) (*jpeg.SegmentList, error) {
+	/*
+		Parse a JPEG file into its segments.
+	*/
+	data, err := ioutil.ReadFile(image_path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJpegSegments(data)
+}
+
+func EncodeJpegSegments(sl *jpeg.SegmentList) ([]byte, error) {
+	/*
+		Join segments back into JPEG file data.
+	*/
+	b := new(bytes.Buffer)
+	err := sl.Write(b)
+	return b.Bytes(), err
+}
+
+func WriteJpegSegments(sl *jpeg.SegmentList, output_path string) error {
+	/*
+		Write a segment list back out as a JPEG file.
+	*/
+	data, err := EncodeJpegSegments(sl)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(output_path, data, 0644)
+}
+
+func ParseJpegMarker(marker_name string) (byte, error) {
+	/*
+		Convert "COM" or "APP0"-"APP15" to its marker byte.
+	*/
+	marker_name = strings.ToUpper(marker_name)
+	if marker_name == "COM" {
+		return JpegMarkerCom, nil
+	}
+	if strings.HasPrefix(marker_name, "APP") {
+		n, err := strconv.Atoi(marker_name[3:])
+		if err == nil && n >= 0 && n <= 15 {
+			return JpegMarkerApp0 + byte(n), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid marker '%s'. Must be 'COM' or 'APP0'-'APP15'", marker_name)
+}
+
+func JpegMarkerName(marker byte) string {
+	if marker == JpegMarkerCom {
+		return "COM"
+	}
+	if marker >= JpegMarkerApp0 && marker <= JpegMarkerApp0+15 {
+		return fmt.Sprintf("APP%d", marker-JpegMarkerApp0)
+	}
+	return fmt.Sprintf("0x%02X", marker)
+}
+
+func InsertJpegSegments(sl *jpeg.SegmentList, new_segments []*jpeg.Segment) *jpeg.SegmentList {
+	/*
+		Add segments after SOI and any existing APPn/COM segments, so
+		that JFIF/EXIF segments stay first.
+	*/
+	segments := sl.Segments()
+	position := 1
+	for position < len(segments) {
+		marker := segments[position].MarkerId
+		if marker != JpegMarkerCom && (marker < JpegMarkerApp0 || marker > JpegMarkerApp0+15) {
+			break
+		}
+		position += 1
+	}
+	var combined []*jpeg.Segment
+	combined = append(combined, segments[:position]...)
+	combined = append(combined, new_segments...)
+	combined = append(combined, segments[position:]...)
+	return jpeg.NewSegmentList(combined)
+}
+
+func EmbedJpegSegments(sl *jpeg.SegmentList, marker byte, payload []byte, raw bool) (*jpeg.SegmentList, error) {
+	/*
+		Embed payload in COM/APPn segments, split over as many as needed to fit the
+		64 KiB segment limit. Each carries an identifier, index and count so it can
+		be reassembled. In raw mode the payload is written as-is to a single segment.
+	*/
+	var new_segments []*jpeg.Segment
+	if raw {
+		if len(payload) > JpegMaxSegmentData {
+			return nil, fmt.Errorf("raw payload is %d bytes, but a segment can only hold %d", len(payload), JpegMaxSegmentData)
+		}
+		new_segments = append(new_segments, &jpeg.Segment{MarkerId: marker, MarkerName: JpegMarkerName(marker), Data: payload})
+		return InsertJpegSegments(sl, new_segments), nil
+	}
+
+	chunk_size := JpegMaxSegmentData - len(jpegChunkIdentifier) - 4
+	count := (len(payload) + chunk_size - 1) / chunk_size
+	if count == 0 {
+		count = 1
+	}
+	if count > 0xffff {
+		return nil, errors.New("payload is too large to split over JPEG segments")
+	}
+	for index := 0; index < count; index++ {
+		end := (index + 1) * chunk_size
+		if end > len(payload) {
+			end = len(payload)
+		}
+		var buf bytes.Buffer
+		buf.Write(jpegChunkIdentifier)
+		binary.Write(&buf, binary.BigEndian, uint16(index))
+		binary.Write(&buf, binary.BigEndian, uint16(count))
+		buf.Write(payload[index*chunk_size : end])
+		new_segments = append(new_segments, &jpeg.Segment{MarkerId: marker, MarkerName: JpegMarkerName(marker), Data: buf.Bytes()})
+	}
+	return InsertJpegSegments(sl, new_segments), nil
+}
+
+func IsJpegChunkSegment(segment *jpeg.Segment) bool {
+	return bytes.HasPrefix(segment.Data, jpegChunkIdentifier) && len(segment.Data) >= len(jpegChunkIdentifier)+4
+}
+
+func ExtractJpegSegments(sl *jpeg.SegmentList, marker byte, raw bool) ([]byte, error) {
+	/*
+		Reassemble a payload embedded with EmbedJpegSegments. In raw mode, the data
+		of every segment with the marker is concatenated instead.
+	*/
+	var chunks [][]byte
+	chunk_count := -1
+	for _, segment := range sl.Segments() {
+		if segment.MarkerId != marker {
+			continue
+		}
+		if raw {
+			chunks = append(chunks, segment.Data)
+			continue
+		}
+		if !IsJpegChunkSegment(segment) {
+			continue
+		}
+		header := segment.Data[len(jpegChunkIdentifier):]
+		index, count := int(binary.BigEndian.Uint16(header[0:2])), int(binary.BigEndian.Uint16(header[2:4]))
+		if chunk_count == -1 {
+			chunk_count = count
+			chunks = make([][]byte, count)
+		}
+		if count != chunk_count || index >= count {
+			return nil, fmt.Errorf("%s segments belong to more than one payload", JpegMarkerName(marker))
+		}
+		chunks[index] = header[4:]
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no %s segments with embedded data found", JpegMarkerName(marker))
+	}
+	for index, chunk := range chunks {
+		if chunk == nil {
+			return nil, fmt.Errorf("%s segment %d/%d is missing", JpegMarkerName(marker), index+1, len(chunks))
+		}
+	}
+	return bytes.Join(chunks, nil), nil
+}
+
+func JpegSegmentSummary(sl *jpeg.SegmentList) []string {
+	/*
+		Describe each segment: its marker, offset, length, and where relevant
+		its stegogo chunk header or a preview of its contents.
+	*/
+	var lines []string
+	for index, segment := range sl.Segments() {
+		name := segment.MarkerName
+		is_metadata := segment.MarkerId == JpegMarkerCom || (segment.MarkerId >= JpegMarkerApp0 && segment.MarkerId <= JpegMarkerApp0+15)
+		if segment.MarkerId == 0 {
+			name = "SCANDATA"
+		} else if is_metadata {
+			name = JpegMarkerName(segment.MarkerId)
+		}
+		line := fmt.Sprintf("%3d %-8s offset %8d %8d bytes", index, name, segment.Offset, len(segment.Data))
+		if IsJpegChunkSegment(segment) {
+			header := segment.Data[len(jpegChunkIdentifier):]
+			line += fmt.Sprintf("  stegogo chunk %d/%d", binary.BigEndian.Uint16(header[0:2])+1, binary.BigEndian.Uint16(header[2:4]))
+		} else if is_metadata {
+			preview := segment.Data
+			if len(preview) > 40 {
+				preview = preview[:40]
+			}
+			line += fmt.Sprintf("  %q", preview)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,260 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+)
+
+var JpegMethods = []string{"jsteg", "f5"}
+
+// F5 header: 4 bits of k, then 28 bits of message length in bytes
+const f5HeaderBits = 32
+
+func (jc *JpegCoefficients) acCoefficients() []*int16 {
+	/*
+		Pointers to every AC coefficient, block by block in file order.
+	*/
+	var coefficients []*int16
+	jc.scanBlocks(func(component int, block *[64]int16) {
+		for k := 1; k < 64; k++ {
+			coefficients = append(coefficients, &block[k])
+		}
+	})
+	return coefficients
+}
+
+func JstegCapacity(jc *JpegCoefficients) int {
+	capacity := 0
+	for _, c := range jc.acCoefficients() {
+		if *c != 0 && *c != 1 {
+			capacity++
+		}
+	}
+	return capacity
+}
+
+func EmbedJsteg(jc *JpegCoefficients, secret_bits []bool) {
+	/*
+		JSteg: replace the LSB of each AC coefficient that isn't 0 or 1. Those are
+		skipped, as changing them would alter which coefficients carry data.
+	*/
+	secret_pos := 0
+	for _, c := range jc.acCoefficients() {
+		if secret_pos == len(secret_bits) {
+			return
+		}
+		if *c == 0 || *c == 1 {
+			continue
+		}
+		*c &^= 1
+		if secret_bits[secret_pos] {
+			*c |= 1
+		}
+		secret_pos++
+	}
+	if secret_pos < len(secret_bits) {
+		fmt.Printf("WARNING: Image too small with given secret -- only %d/%d bits embedded.\n", secret_pos, len(secret_bits))
+	}
+}
+
+func ExtractJsteg(jc *JpegCoefficients) []bool {
+	var bits []bool
+	for _, c := range jc.acCoefficients() {
+		if *c != 0 && *c != 1 {
+			bits = append(bits, *c&1 == 1)
+		}
+	}
+	return bits
+}
+
+// Walks the shuffled coefficients, skipping zeros
+type f5Walker struct {
+	coefficients []*int16
+	pos          int
+}
+
+func (w *f5Walker) next() *int16 {
+	for w.pos < len(w.coefficients) {
+		c := w.coefficients[w.pos]
+		w.pos++
+		if *c != 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+func f5Bit(value int16) bool {
+	/*
+		F5's bit for a nonzero coefficient: the LSB for positive values, and its
+		inverse for negative ones, so reducing the magnitude always flips it.
+	*/
+	if value > 0 {
+		return value&1 == 1
+	}
+	return (-value)&1 == 0
+}
+
+func f5Shrink(c *int16) {
+	if *c > 0 {
+		*c--
+	} else {
+		*c++
+	}
+}
+
+func f5Coefficients(jc *JpegCoefficients, key string) []*int16 {
+	coefficients := jc.acCoefficients()
+	r := keyRand(key, "f5")
+	r.Shuffle(len(coefficients), func(i, j int) {
+		coefficients[i], coefficients[j] = coefficients[j], coefficients[i]
+	})
+	return coefficients
+}
+
+var errF5Full = errors.New("JPEG too small: ran out of coefficients while embedding with F5")
+
+func f5Embed(w *f5Walker, bits []bool, k int) error {
+	/*
+		Embed bits k at a time with (1, 2^k-1, k) matrix encoding: the XOR of the
+		(1-based) positions of a group's 1 bits must equal the message bits, which
+		needs at most one coefficient changed. If the change shrinks a
+		coefficient to zero, it no longer counts, so the group is refilled and
+		the bits embedded again.
+	*/
+	n := (1 << uint(k)) - 1
+	for start := 0; start < len(bits); start += k {
+		message := 0
+		for i := 0; i < k; i++ {
+			message <<= 1
+			if start+i < len(bits) && bits[start+i] {
+				message |= 1
+			}
+		}
+		group := make([]*int16, 0, n)
+		for len(group) < n {
+			c := w.next()
+			if c == nil {
+				return errF5Full
+			}
+			group = append(group, c)
+		}
+		for {
+			hash := 0
+			for i, c := range group {
+				if f5Bit(*c) {
+					hash ^= i + 1
+				}
+			}
+			change := hash ^ message
+			if change == 0 {
+				break
+			}
+			c := group[change-1]
+			f5Shrink(c)
+			if *c != 0 {
+				break
+			}
+			group = append(group[:change-1], group[change:]...)
+			next := w.next()
+			if next == nil {
+				return errF5Full
+			}
+			group = append(group, next)
+		}
+	}
+	return nil
+}
+
+func f5Extract(w *f5Walker, count int, k int) ([]bool, error) {
+	n := (1 << uint(k)) - 1
+	var bits []bool
+	for len(bits) < count {
+		hash := 0
+		for i := 0; i < n; i++ {
+			c := w.next()
+			if c == nil {
+				return nil, errors.New("F5 data runs past the end of the image. Is the key right?")
+			}
+			if f5Bit(*c) {
+				hash ^= i + 1
+			}
+		}
+		for i := k - 1; i >= 0; i-- {
+			bits = append(bits, hash>>uint(i)&1 == 1)
+		}
+	}
+	return bits[:count], nil
+}
+
+func EmbedF5(jc *JpegCoefficients, secret []byte, key string) (int, error) {
+	/*
+		F5: embed in the nonzero AC coefficients, in an order shuffled by the key,
+		by reducing their magnitude. A header holding the matrix encoding
+		parameter k and the length is embedded first with k=1, then the data with
+		the largest k that's expected to fit. Returns k.
+	*/
+	if len(secret) >= 1<<28 {
+		return 0, errors.New("secret too large for F5: must be under 256 MiB")
+	}
+	coefficients := f5Coefficients(jc, key)
+
+	// Estimate capacity: about half of the ±1 coefficients shrink to zero when changed
+	nonzero, ones := 0, 0
+	for _, c := range coefficients {
+		if *c != 0 {
+			nonzero++
+		}
+		if *c == 1 || *c == -1 {
+			ones++
+		}
+	}
+	usable := nonzero - ones/2 - f5HeaderBits
+	message_bits := len(secret) * 8
+	k := 0
+	for candidate := 7; candidate >= 1; candidate-- {
+		groups := (message_bits + candidate - 1) / candidate
+		if groups*((1<<uint(candidate))-1) <= usable {
+			k = candidate
+			break
+		}
+	}
+	if k == 0 {
+		return 0, fmt.Errorf("JPEG too small: F5 can embed about %d bytes", usable/8)
+	}
+
+	header := uint32(k)<<28 | uint32(len(secret))
+	header_bits := make([]bool, f5HeaderBits)
+	for i := range header_bits {
+		header_bits[i] = header>>uint(f5HeaderBits-1-i)&1 == 1
+	}
+	w := &f5Walker{coefficients: coefficients}
+	if err := f5Embed(w, header_bits, 1); err != nil {
+		return 0, err
+	}
+	return k, f5Embed(w, BytesToBitstream(secret), k)
+}
+
+func ExtractF5(jc *JpegCoefficients, key string) ([]byte, error) {
+	w := &f5Walker{coefficients: f5Coefficients(jc, key)}
+	header_bits, err := f5Extract(w, f5HeaderBits, 1)
+	if err != nil {
+		return nil, err
+	}
+	header := uint32(0)
+	for _, bit := range header_bits {
+		header <<= 1
+		if bit {
+			header |= 1
+		}
+	}
+	k, length := int(header>>28), int(header&(1<<28-1))
+	if k < 1 || k > 7 {
+		return nil, errors.New("no F5 header found. Is the key right?")
+	}
+	bits, err := f5Extract(w, length*8, k)
+	if err != nil {
+		return nil, err
+	}
+	return BitstreamToBytes(bits), nil
+}
@@ -1,9 +1,9 @@
 package lib
 
 import (
+	"errors"
 	"fmt"
 	"image"
-	"image/draw"
 )
 
 func EmbedLsb(bitplane_args []string, secret_bitstream []bool, cover_img image.Image, order string) (image.Image, error) {
@@ -13,17 +13,16 @@ func EmbedLsb(bitplane_args []string, secret_bitstream []bool, cover_img image.I
 		return nil, err
 	}
 
-	// Create image copy (for faster pixel read and write)
-	bounds := cover_img.Bounds()
-	width, height := bounds.Max.X, bounds.Max.Y
-	new_img := image.NewNRGBA(bounds)
-	draw.Draw(new_img, bounds, cover_img, bounds.Min, draw.Src)
-
-	// Check number of values per pixel in image
-	values_per_pixel, err := GetValuesPerPixel(cover_img)
+	// Create image copy (for faster pixel read and write), greyscale if the cover is
+	new_img, pix_arr, values_per_pixel, err := newCanvas(cover_img)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkLsbPlanes(bitplane_operations, values_per_pixel); err != nil {
+		return nil, err
+	}
+	bounds := cover_img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
 
 	// Iterate through all pixels and embed data
 	secret_pos := 0
@@ -45,18 +44,13 @@ func EmbedLsb(bitplane_args []string, secret_bitstream []bool, cover_img image.I
 				index = (b * width * values_per_pixel) + (a * values_per_pixel)
 			}
 			// Get pixel colours
-			pix := new_img.Pix[index : index+values_per_pixel]
+			pix := pix_arr[index : index+values_per_pixel]
 			for _, embed_instruction := range bitplane_operations {
 				// Get bit position and colour from instruction
 				colour := embed_instruction[0].(int)
 				bit_pos := embed_instruction[1].(int)
 				// Flip bit to either 0 or 1 depending on secret data
-				if secret_bitstream[secret_pos] {
-					pix[colour] |= (1 << bit_pos)
-				} else {
-					mask := ^(1 << bit_pos)
-					pix[colour] &= uint8(mask)
-				}
+				pix[colour] = setBit(pix[colour], bit_pos, secret_bitstream[secret_pos])
 				// Return if secret stream end reached
 				secret_pos += 1
 				if secret_pos == len(secret_bitstream) {
@@ -69,6 +63,17 @@ func EmbedLsb(bitplane_args []string, secret_bitstream []bool, cover_img image.I
 	return new_img, nil
 }
 
+func setBit(value uint8, bit_pos int, bit bool) uint8 {
+	/*
+		Set a single bit of a pixel value to 0 or 1.
+	*/
+	if bit {
+		return value | (1 << bit_pos)
+	}
+	mask := ^(1 << bit_pos)
+	return value & uint8(mask)
+}
+
 func ExtractLsb(bitplane_args []string, input_img image.Image, order string) ([]bool, error) {
 	// Parse bitplans operation input
 	bitplane_operations, err := BitplaneArgsToArray(bitplane_args)
@@ -77,16 +82,15 @@ func ExtractLsb(bitplane_args []string, input_img image.Image, order string) ([]
 	}
 
 	// Open image as readable object
-	bounds := input_img.Bounds()
-	width, height := bounds.Max.X, bounds.Max.Y
-	parsable_img := image.NewNRGBA(bounds)
-	draw.Draw(parsable_img, bounds, input_img, bounds.Min, draw.Src)
-
-	// Check number of values per pixel in image
-	values_per_pixel, err := GetValuesPerPixel(input_img)
+	_, pix_arr, values_per_pixel, err := newCanvas(input_img)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkLsbPlanes(bitplane_operations, values_per_pixel); err != nil {
+		return nil, err
+	}
+	bounds := input_img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
 
 	// Determine which way to read image
 	var xy_1 int
@@ -108,7 +112,7 @@ func ExtractLsb(bitplane_args []string, input_img image.Image, order string) ([]
 				index = (b * width * values_per_pixel) + (a * values_per_pixel)
 			}
 			// Get RGBA values for pixel
-			pix := parsable_img.Pix[index : index+values_per_pixel]
+			pix := pix_arr[index : index+values_per_pixel]
 			for _, embed_instruction := range bitplane_operations {
 				colour := embed_instruction[0].(int)
 				bit_pos := embed_instruction[1].(int)
@@ -123,3 +127,15 @@ func ExtractLsb(bitplane_args []string, input_img image.Image, order string) ([]
 	}
 	return bitstream, nil
 }
+
+func checkLsbPlanes(bitplane_operations [][]interface{}, values_per_pixel int) error {
+	/*
+		Greyscale images have a single value per pixel, so only R planes exist.
+	*/
+	for _, operation := range bitplane_operations {
+		if operation[0].(int) >= values_per_pixel {
+			return errors.New("greyscale images only have one colour plane. Use R planes, i.e., \"R0\"")
+		}
+	}
+	return nil
+}
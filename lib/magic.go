@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"bytes"
+)
+
+// Magic bytes of common file types, used to recognise extracted data
+var fileSignatures = [][]interface{}{
+	{"png", []byte("\x89PNG\r\n\x1a\n")},
+	{"jpeg", []byte{0xff, 0xd8, 0xff}},
+	{"gif", []byte("GIF8")},
+	{"bmp", []byte("BM")},
+	{"zip", []byte("PK\x03\x04")},
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"bzip2", []byte("BZh")},
+	{"7z", []byte("7z\xbc\xaf\x27\x1c")},
+	{"rar", []byte("Rar!\x1a\x07")},
+	{"pdf", []byte("%PDF-")},
+	{"elf", []byte("\x7fELF")},
+	{"wav", []byte("RIFF")},
+	{"ogg", []byte("OggS")},
+	{"mp3", []byte("ID3")},
+}
+
+func DetectFileType(data []byte) string {
+	/*
+		Return the name of the file type the data starts with, or "" if unknown.
+	*/
+	for _, signature := range fileSignatures {
+		if bytes.HasPrefix(data, signature[1].([]byte)) {
+			return signature[0].(string)
+		}
+	}
+	return ""
+}
+
+func PlausibilityScore(data []byte) float64 {
+	/*
+		Score extracted data between 0 and 1 for how likely it is to be a real
+		payload rather than noise. Known file signatures score 1, otherwise the
+		score is the fraction of printable text in the first 512 bytes.
+	*/
+	if DetectFileType(data) != "" {
+		return 1
+	}
+	if len(data) > 512 {
+		data = data[:512]
+	}
+	if len(data) == 0 {
+		return 0
+	}
+	printable := 0
+	for _, b := range data {
+		if (b >= 0x20 && b < 0x7f) || b == '\n' || b == '\r' || b == '\t' {
+			printable += 1
+		}
+	}
+	return float64(printable) / float64(len(data))
+}
@@ -0,0 +1,486 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+type netpbmHeader struct {
+	magic         string
+	width, height int
+	depth         int
+	maxval        int
+	tuple_type    string
+	raster_offset int
+}
+
+// A Netpbm image with a maxval other than 255 or 65535. Raw (image.Gray16 or
+// image.NRGBA64) holds the samples as they are in the file, 0 to Maxval, so they
+// can be embedded in and written back exactly, while At scales them to the full range.
+type NetpbmImage struct {
+	Raw    image.Image
+	Maxval int
+}
+
+func (img *NetpbmImage) ColorModel() color.Model {
+	return img.Raw.ColorModel()
+}
+
+func (img *NetpbmImage) Bounds() image.Rectangle {
+	return img.Raw.Bounds()
+}
+
+func (img *NetpbmImage) At(x, y int) color.Color {
+	c := color.NRGBA64Model.Convert(img.Raw.At(x, y)).(color.NRGBA64)
+	scale := func(v uint16) uint16 {
+		return uint16((int(v)*65535 + img.Maxval/2) / img.Maxval)
+	}
+	if img.Raw.ColorModel() == color.Gray16Model {
+		return color.Gray16{scale(c.R)}
+	}
+	return color.NRGBA64{scale(c.R), scale(c.G), scale(c.B), scale(c.A)}
+}
+
+func (img *NetpbmImage) Opaque() bool {
+	if img.Raw.ColorModel() == color.Gray16Model {
+		return true
+	}
+	bounds := img.Raw.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if int(color.NRGBA64Model.Convert(img.Raw.At(x, y)).(color.NRGBA64).A) != img.Maxval {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func init() {
+	for _, magic := range []string{"P2", "P3", "P5", "P6", "P7"} {
+		image.RegisterFormat("netpbm", magic, DecodeNetpbm, DecodeNetpbmConfig)
+	}
+}
+
+func netpbmToken(data []byte, pos int) (string, int, error) {
+	/*
+		Read the next whitespace-separated token from pos, skipping '#' comments.
+	*/
+	for pos < len(data) {
+		if data[pos] == '#' {
+			for pos < len(data) && data[pos] != '\n' && data[pos] != '\r' {
+				pos++
+			}
+		} else if isNetpbmSpace(data[pos]) {
+			pos++
+		} else {
+			break
+		}
+	}
+	start := pos
+	for pos < len(data) && !isNetpbmSpace(data[pos]) && data[pos] != '#' {
+		pos++
+	}
+	if start == pos {
+		return "", pos, errors.New("Netpbm header is truncated")
+	}
+	return string(data[start:pos]), pos, nil
+}
+
+func isNetpbmSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+func readNetpbmHeader(data []byte) (netpbmHeader, error) {
+	/*
+		Parse the header of a PGM/PPM (P2, P3, P5, P6) or PAM (P7) image.
+	*/
+	var header netpbmHeader
+	if len(data) < 3 || data[0] != 'P' {
+		return header, errors.New("file is not a Netpbm image")
+	}
+	header.magic = string(data[0:2])
+	if header.magic == "P7" {
+		return readPamHeader(data)
+	}
+	switch header.magic {
+	case "P2", "P5":
+		header.depth = 1
+	case "P3", "P6":
+		header.depth = 3
+	default:
+		return header, fmt.Errorf("unsupported Netpbm type '%s'. Only P2, P3, P5, P6 and P7 are supported", header.magic)
+	}
+	pos := 2
+	values := make([]int, 3)
+	for i := range values {
+		var token string
+		var err error
+		token, pos, err = netpbmToken(data, pos)
+		if err != nil {
+			return header, err
+		}
+		values[i], err = strconv.Atoi(token)
+		if err != nil {
+			return header, fmt.Errorf("invalid Netpbm header value '%s'", token)
+		}
+	}
+	header.width, header.height, header.maxval = values[0], values[1], values[2]
+	// Exactly one whitespace character separates the header from the raster
+	header.raster_offset = pos + 1
+	return header, validateNetpbmHeader(header)
+}
+
+func readPamHeader(data []byte) (netpbmHeader, error) {
+	/*
+		Parse a PAM header, made of "KEY value" lines up to ENDHDR.
+	*/
+	header := netpbmHeader{magic: "P7"}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	pos := len(lines[0])
+	for _, line := range lines[1:] {
+		pos += len(line)
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "ENDHDR" {
+			header.raster_offset = pos
+			return header, validateNetpbmHeader(header)
+		}
+		if fields[0] == "TUPLTYPE" {
+			header.tuple_type = strings.TrimSpace(strings.Join(fields[1:], " "))
+			continue
+		}
+		if len(fields) != 2 {
+			return header, fmt.Errorf("invalid PAM header line '%s'", strings.TrimSpace(string(line)))
+		}
+		value, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return header, fmt.Errorf("invalid PAM header value '%s'", fields[1])
+		}
+		switch fields[0] {
+		case "WIDTH":
+			header.width = value
+		case "HEIGHT":
+			header.height = value
+		case "DEPTH":
+			header.depth = value
+		case "MAXVAL":
+			header.maxval = value
+		}
+	}
+	return header, errors.New("PAM header has no ENDHDR")
+}
+
+func validateNetpbmHeader(header netpbmHeader) error {
+	if header.width <= 0 || header.height <= 0 {
+		return errors.New("Netpbm image has no pixels")
+	}
+	if header.maxval < 1 || header.maxval > 65535 {
+		return fmt.Errorf("invalid Netpbm maxval %d. Must be between 1 and 65535", header.maxval)
+	}
+	if header.depth < 1 || header.depth > 4 {
+		return fmt.Errorf("unsupported PAM depth %d. Only 1 to 4 channels are supported", header.depth)
+	}
+	return nil
+}
+
+func netpbmColorModel(header netpbmHeader) color.Model {
+	deep := header.maxval != 255
+	switch {
+	case header.depth == 1 && deep:
+		return color.Gray16Model
+	case header.depth == 1:
+		return color.GrayModel
+	case deep:
+		return color.NRGBA64Model
+	}
+	return color.NRGBAModel
+}
+
+func DecodeNetpbmConfig(r io.Reader) (image.Config, error) {
+	/*
+		Read just the header, which comments can make any length, so the file is
+		read until it parses.
+	*/
+	var data []byte
+	chunk := make([]byte, 4096)
+	for {
+		n, read_err := io.ReadFull(r, chunk)
+		data = append(data, chunk[:n]...)
+		header, err := readNetpbmHeader(data)
+		if err == nil && header.raster_offset <= len(data) {
+			return image.Config{ColorModel: netpbmColorModel(header), Width: header.width, Height: header.height}, nil
+		}
+		if read_err == io.EOF || read_err == io.ErrUnexpectedEOF {
+			if err == nil {
+				err = errors.New("Netpbm header is truncated")
+			}
+			return image.Config{}, err
+		}
+		if read_err != nil {
+			return image.Config{}, read_err
+		}
+	}
+}
+
+func DecodeNetpbm(r io.Reader) (image.Image, error) {
+	/*
+		Decode a PGM, PPM or PAM image. Samples with a maxval of 255 become 8-bit
+		and those with a maxval of 65535 16-bit. Greyscale images become image.Gray
+		or image.Gray16 and everything else image.NRGBA or image.NRGBA64, so pixel
+		values are exactly those in the file. Any other maxval gives a NetpbmImage,
+		which keeps the samples unscaled.
+	*/
+	data, err := ioutil.ReadAll(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	header, err := readNetpbmHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the data can hold every sample before allocating them. Each sample
+	// takes at least sample_size bytes, or a digit and whitespace if plain
+	sample_size := 1
+	if header.maxval > 255 {
+		sample_size = 2
+	}
+	available := len(data) - header.raster_offset
+	if header.magic == "P2" || header.magic == "P3" {
+		sample_size, available = 2, available+1
+	}
+	if available < 0 || header.width > available/sample_size/header.height/header.depth {
+		return nil, errors.New("Netpbm pixel data is truncated")
+	}
+
+	// Read every sample, scaled to 16 bits unless kept as they are
+	raw := header.maxval != 255 && header.maxval != 65535
+	samples := make([]uint16, header.width*header.height*header.depth)
+	scale := func(v int) (uint16, error) {
+		if v > header.maxval {
+			return 0, fmt.Errorf("Netpbm sample %d is larger than maxval %d", v, header.maxval)
+		}
+		if raw {
+			return uint16(v), nil
+		}
+		return uint16((v*65535 + header.maxval/2) / header.maxval), nil
+	}
+	if header.magic == "P2" || header.magic == "P3" {
+		pos := header.raster_offset - 1
+		for i := range samples {
+			var token string
+			token, pos, err = netpbmToken(data, pos)
+			if err != nil {
+				return nil, errors.New("Netpbm pixel data is truncated")
+			}
+			v, err := strconv.Atoi(token)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Netpbm sample '%s'", token)
+			}
+			if samples[i], err = scale(v); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		raster := data[header.raster_offset:]
+		if len(raster) < len(samples)*sample_size {
+			return nil, errors.New("Netpbm pixel data is truncated")
+		}
+		for i := range samples {
+			v := int(raster[i*sample_size])
+			if sample_size == 2 {
+				v = v<<8 | int(raster[i*sample_size+1])
+			}
+			if samples[i], err = scale(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Build image
+	bounds := image.Rect(0, 0, header.width, header.height)
+	deep := header.maxval > 255 || raw
+	opaque := uint16(0xffff)
+	if raw {
+		opaque = uint16(header.maxval)
+	}
+	var img image.Image
+	var set func(i int, c color.NRGBA64)
+	switch {
+	case header.depth == 1 && deep:
+		gray := image.NewGray16(bounds)
+		for i, v := range samples {
+			gray.Pix[i*2], gray.Pix[i*2+1] = uint8(v>>8), uint8(v)
+		}
+		img = gray
+	case header.depth == 1:
+		gray := image.NewGray(bounds)
+		for i, v := range samples {
+			gray.Pix[i] = uint8(v >> 8)
+		}
+		img = gray
+	case deep:
+		nrgba := image.NewNRGBA64(bounds)
+		set = func(i int, c color.NRGBA64) { nrgba.SetNRGBA64(i%header.width, i/header.width, c) }
+		img = nrgba
+	default:
+		nrgba := image.NewNRGBA(bounds)
+		set = func(i int, c color.NRGBA64) {
+			nrgba.SetNRGBA(i%header.width, i/header.width, color.NRGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)})
+		}
+		img = nrgba
+	}
+	for i := 0; i < header.width*header.height && set != nil; i++ {
+		tuple := samples[i*header.depth : (i+1)*header.depth]
+		switch header.depth {
+		case 2:
+			// Greyscale with alpha
+			set(i, color.NRGBA64{tuple[0], tuple[0], tuple[0], tuple[1]})
+		case 3:
+			set(i, color.NRGBA64{tuple[0], tuple[1], tuple[2], opaque})
+		case 4:
+			set(i, color.NRGBA64{tuple[0], tuple[1], tuple[2], tuple[3]})
+		}
+	}
+	if raw {
+		return &NetpbmImage{Raw: img, Maxval: header.maxval}, nil
+	}
+	return img, nil
+}
+
+func IsDeepImage(img image.Image) bool {
+	/*
+		Check whether an image has 16-bit samples.
+	*/
+	switch img.ColorModel() {
+	case color.Gray16Model, color.RGBA64Model, color.NRGBA64Model:
+		return true
+	}
+	return false
+}
+
+func CheckCoverDepth(img image.Image) error {
+	/*
+		Check a cover's samples can be embedded in and written back exactly. A
+		Netpbm cover's maxval is kept, and embedding changes the low byte of
+		each sample, so its maxval must be 2^n-1 and at least 255.
+	*/
+	netpbm_img, ok := img.(*NetpbmImage)
+	if ok && (netpbm_img.Maxval < 255 || netpbm_img.Maxval&(netpbm_img.Maxval+1) != 0) {
+		return fmt.Errorf("cover is a Netpbm image with a maxval of %d, which embedding could exceed. The maxval must be one less than a power of two, and at least 255", netpbm_img.Maxval)
+	}
+	return nil
+}
+
+func EncodeNetpbm(w io.Writer, img image.Image, format string) error {
+	/*
+		Encode an image as binary PGM (P5), PPM (P6) or PAM (P7), or as plain PGM
+		(P2) or PPM (P3) with the "pgm-plain" and "ppm-plain" formats. "pnm" picks
+		PGM for greyscale images and PPM otherwise. Images with 16-bit samples are
+		written with a maxval of 65535, others 255, and a NetpbmImage with its own
+		maxval and samples. PGM and PPM have no alpha
+		channel, so any transparency is dropped; PAM keeps it.
+	*/
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	is_gray := img.ColorModel() == color.GrayModel || img.ColorModel() == color.Gray16Model
+	if format == "pnm" {
+		format = "ppm"
+		if is_gray {
+			format = "pgm"
+		}
+	}
+	maxval := 255
+	if IsDeepImage(img) {
+		maxval = 65535
+	}
+	src := img
+	if netpbm_img, ok := img.(*NetpbmImage); ok {
+		src, maxval = netpbm_img.Raw, netpbm_img.Maxval
+	}
+
+	// Channels and header
+	bw := bufio.NewWriter(w)
+	depth := 3
+	switch format {
+	case "pgm", "pgm-plain":
+		depth = 1
+	case "pam":
+		tuple_type := "RGB"
+		if is_gray {
+			depth, tuple_type = 1, "GRAYSCALE"
+		}
+		if opaque, ok := img.(interface{ Opaque() bool }); !ok || !opaque.Opaque() {
+			depth, tuple_type = depth+1, tuple_type+"_ALPHA"
+		}
+		fmt.Fprintf(bw, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH %d\nMAXVAL %d\nTUPLTYPE %s\nENDHDR\n", width, height, depth, maxval, tuple_type)
+	}
+	switch format {
+	case "pgm", "ppm", "pgm-plain", "ppm-plain":
+		magic := map[string]string{"pgm": "P5", "ppm": "P6", "pgm-plain": "P2", "ppm-plain": "P3"}[format]
+		fmt.Fprintf(bw, "%s\n%d %d\n%d\n", magic, width, height, maxval)
+	case "pam":
+	default:
+		return fmt.Errorf("unsupported Netpbm format '%s'", format)
+	}
+
+	// Pixels, as 16-bit values reduced to the sample size
+	plain := strings.HasSuffix(format, "-plain")
+	line_length := 0
+	tuple := make([]uint16, 0, 4)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			tuple = tuple[:0]
+			c := color.NRGBA64Model.Convert(src.At(x, y)).(color.NRGBA64)
+			if depth <= 2 {
+				// Same luminance weights as color.Gray16Model, without premultiplying
+				tuple = append(tuple, uint16((19595*uint32(c.R)+38470*uint32(c.G)+7471*uint32(c.B)+1<<15)>>16))
+			}
+			if depth >= 3 {
+				tuple = append(tuple, c.R, c.G, c.B)
+			}
+			if depth == 2 || depth == 4 {
+				tuple = append(tuple, c.A)
+			}
+			for _, v := range tuple {
+				if maxval == 255 {
+					v >>= 8
+				}
+				if !plain {
+					if maxval > 255 {
+						bw.WriteByte(uint8(v >> 8))
+					}
+					bw.WriteByte(uint8(v))
+					continue
+				}
+				// Plain lines should be no longer than 70 characters
+				token := strconv.Itoa(int(v))
+				if line_length > 0 && line_length+1+len(token) > 70 {
+					bw.WriteByte('\n')
+					line_length = 0
+				} else if line_length > 0 {
+					bw.WriteByte(' ')
+					line_length++
+				}
+				bw.WriteString(token)
+				line_length += len(token)
+			}
+		}
+		if plain {
+			bw.WriteByte('\n')
+			line_length = 0
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,418 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"math/big"
+	"sort"
+)
+
+// Palette steganography methods
+var PaletteMethods = []string{"ezstego", "permutation"}
+
+func ReadPalettedImage(data []byte) (*gif.GIF, error) {
+	/*
+		Decode every frame of a GIF, or a paletted PNG as a single frame.
+	*/
+	if bytes.HasPrefix(data, []byte("GIF8")) {
+		return gif.DecodeAll(bytes.NewReader(data))
+	}
+	if !bytes.HasPrefix(data, PngSignature) {
+		return nil, errors.New("file is not a GIF or PNG")
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	paletted, ok := img.(*image.Paletted)
+	if !ok {
+		return nil, errors.New("image isn't paletted. Only GIFs and paletted (PNG8) PNGs can be used")
+	}
+	return &gif.GIF{Image: []*image.Paletted{paletted}, Delay: []int{0}}, nil
+}
+
+func OpenPalettedImage(image_path string) (*gif.GIF, []byte, error) {
+	/*
+		Read a GIF or paletted PNG file, returning its frames and the file's data.
+	*/
+	data, err := ioutil.ReadFile(image_path)
+	if err != nil {
+		return nil, nil, err
+	}
+	g, err := ReadPalettedImage(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return g, data, nil
+}
+
+func SavePalettedLike(g *gif.GIF, output_path string, cover_data []byte) error {
+	/*
+		Write paletted frames in the cover's format: a GIF, which keeps the cover's
+		delays, disposal methods and loop count, or a PNG stored like the cover.
+	*/
+	var output_bytes bytes.Buffer
+	if bytes.HasPrefix(cover_data, []byte("GIF8")) {
+		if err := gif.EncodeAll(&output_bytes, g); err != nil {
+			return err
+		}
+	} else {
+		png_format, err := ReadPngFormat(cover_data)
+		if err != nil {
+			return err
+		}
+		if err := EncodePngLike(&output_bytes, g.Image[0], png_format); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(output_path, output_bytes.Bytes(), 0644)
+}
+
+func copyGif(g *gif.GIF) *gif.GIF {
+	/*
+		Copy a GIF's frame list, so frames can be replaced without changing the original.
+	*/
+	copied := *g
+	copied.Image = append([]*image.Paletted(nil), g.Image...)
+	return &copied
+}
+
+func paletteKey(c color.Color) color.NRGBA64 {
+	/*
+		Compare palette entries without premultiplying, so transparent entries with
+		different colours stay distinct.
+	*/
+	if nrgba, ok := c.(color.NRGBA); ok {
+		return color.NRGBA64{uint16(nrgba.R) * 257, uint16(nrgba.G) * 257, uint16(nrgba.B) * 257, uint16(nrgba.A) * 257}
+	}
+	return color.NRGBA64Model.Convert(c).(color.NRGBA64)
+}
+
+func ezStegoPairs(palette color.Palette) ([]int, []bool) {
+	/*
+		Sort palette indices by luminance (grouped by alpha) and pair neighbours.
+		Returns each index's partner and the bit it carries, the parity of its
+		position. Indices without a partner of the same alpha are -1, since swapping
+		them would show.
+	*/
+	keys := make([]color.NRGBA64, len(palette))
+	luminance := make([]float64, len(palette))
+	order := make([]int, len(palette))
+	for i, c := range palette {
+		keys[i] = paletteKey(c)
+		luminance[i] = 0.299*float64(keys[i].R) + 0.587*float64(keys[i].G) + 0.114*float64(keys[i].B)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if keys[a].A != keys[b].A {
+			return keys[a].A < keys[b].A
+		}
+		return luminance[a] < luminance[b]
+	})
+	partners := make([]int, len(palette))
+	bits := make([]bool, len(palette))
+	for position, index := range order {
+		partners[index] = -1
+		bits[index] = position%2 == 1
+		partner := position ^ 1
+		if partner < len(order) && keys[order[partner]].A == keys[index].A {
+			partners[index] = order[partner]
+		}
+	}
+	return partners, bits
+}
+
+func embedEzStegoFrame(img *image.Paletted, secret_bits []bool) (*image.Paletted, int) {
+	/*
+		Embed bits in one frame, returning the new frame and the number of bits embedded.
+	*/
+	new_img := image.NewPaletted(img.Bounds(), img.Palette)
+	copy(new_img.Pix, img.Pix)
+	partners, bits := ezStegoPairs(img.Palette)
+	secret_pos := 0
+	for i, index := range new_img.Pix {
+		if secret_pos == len(secret_bits) {
+			break
+		}
+		if int(index) >= len(partners) || partners[index] == -1 {
+			continue
+		}
+		if bits[index] != secret_bits[secret_pos] {
+			new_img.Pix[i] = uint8(partners[index])
+		}
+		secret_pos++
+	}
+	return new_img, secret_pos
+}
+
+func EmbedEzStego(g *gif.GIF, secret_bits []bool) *gif.GIF {
+	/*
+		EzStego: with the palette sorted by luminance, each pixel carries one bit as
+		the parity of its colour's position, and is changed to its neighbour in the
+		sorted palette, a very similar colour, when the parity doesn't match. The
+		payload continues from one frame to the next.
+	*/
+	new_gif := copyGif(g)
+	secret_pos := 0
+	for f, frame := range g.Image {
+		var count int
+		new_gif.Image[f], count = embedEzStegoFrame(frame, secret_bits[secret_pos:])
+		secret_pos += count
+		if secret_pos == len(secret_bits) {
+			return new_gif
+		}
+	}
+	fmt.Printf("WARNING: Image too small with given secret -- only %d/%d bits embedded.\n", secret_pos, len(secret_bits))
+	return new_gif
+}
+
+func ExtractEzStego(g *gif.GIF) []bool {
+	var bitstream []bool
+	for _, frame := range g.Image {
+		partners, bits := ezStegoPairs(frame.Palette)
+		for _, index := range frame.Pix {
+			if int(index) < len(partners) && partners[index] != -1 {
+				bitstream = append(bitstream, bits[index])
+			}
+		}
+	}
+	return bitstream
+}
+
+func paletteColours(palette color.Palette) ([]color.NRGBA64, map[color.NRGBA64]int) {
+	/*
+		Return the distinct colours of a palette in order of first appearance, and
+		the index each first appears at.
+	*/
+	var colours []color.NRGBA64
+	first_index := make(map[color.NRGBA64]int)
+	for i, c := range palette {
+		key := paletteKey(c)
+		if _, ok := first_index[key]; !ok {
+			first_index[key] = i
+			colours = append(colours, key)
+		}
+	}
+	return colours, first_index
+}
+
+func sortedColours(colours []color.NRGBA64) []color.NRGBA64 {
+	sorted := append([]color.NRGBA64(nil), colours...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.R != b.R {
+			return a.R < b.R
+		}
+		if a.G != b.G {
+			return a.G < b.G
+		}
+		if a.B != b.B {
+			return a.B < b.B
+		}
+		return a.A < b.A
+	})
+	return sorted
+}
+
+func paletteCapacity(palette color.Palette) int {
+	/*
+		Number of whole bytes the order of a palette's n distinct colours can hold,
+		floor(log2(n!) / 8).
+	*/
+	colours, _ := paletteColours(palette)
+	if len(colours) < 2 {
+		return 0
+	}
+	return (new(big.Int).MulRange(1, int64(len(colours))).BitLen() - 1) / 8
+}
+
+func permutePalette(palette color.Palette, secret []byte) (color.Palette, []uint8) {
+	/*
+		Reorder a palette's distinct colours so the order encodes secret, which must
+		fit in its capacity, as a Lehmer code relative to the colours sorted by value.
+		Duplicate entries are moved to the end. Returns the new palette and the new
+		index of each old one. The palette is padded to a power of two with duplicates.
+	*/
+	padded := make([]byte, paletteCapacity(palette))
+	copy(padded, secret)
+	value := new(big.Int).SetBytes(padded)
+
+	// Pick each colour in turn from those remaining, most significant digit first
+	colours, first_index := paletteColours(palette)
+	remaining := sortedColours(colours)
+	var new_palette color.Palette
+	remap := make([]uint8, len(palette))
+	is_first := make(map[int]bool)
+	for n := len(remaining); n > 0; n-- {
+		digit := new(big.Int)
+		value.DivMod(value, new(big.Int).MulRange(1, int64(n-1)), digit)
+		value, digit = digit, value
+		c := remaining[digit.Int64()]
+		remaining = append(remaining[:digit.Int64()], remaining[digit.Int64()+1:]...)
+		remap[first_index[c]] = uint8(len(new_palette))
+		is_first[first_index[c]] = true
+		new_palette = append(new_palette, palette[first_index[c]])
+	}
+	for i, c := range palette {
+		if !is_first[i] {
+			remap[i] = uint8(len(new_palette))
+			new_palette = append(new_palette, c)
+		}
+	}
+	// GIF encoders pad palettes to a power of two, usually with black, which would be
+	// read back as another colour. Pad with a duplicate instead, which isn't.
+	size := 2
+	for size < len(new_palette) {
+		size *= 2
+	}
+	for len(new_palette) > 0 && len(new_palette) < size {
+		new_palette = append(new_palette, new_palette[0])
+	}
+	return new_palette, remap
+}
+
+func readPalettePermutation(palette color.Palette) []byte {
+	/*
+		Read the order of a palette's distinct colours back into a number.
+	*/
+	colours, _ := paletteColours(palette)
+	remaining := sortedColours(colours)
+	value := new(big.Int)
+	for _, c := range colours {
+		digit := 0
+		for remaining[digit] != c {
+			digit++
+		}
+		remaining = append(remaining[:digit], remaining[digit+1:]...)
+		value.Mul(value, big.NewInt(int64(len(remaining)+1)))
+		value.Add(value, big.NewInt(int64(digit)))
+	}
+	capacity := paletteCapacity(palette)
+	// Palettes not ordered by embedding can hold larger numbers, so keep the low bytes
+	value_bytes := value.Bytes()
+	if len(value_bytes) > capacity {
+		value_bytes = value_bytes[len(value_bytes)-capacity:]
+	}
+	output_bytes := make([]byte, capacity)
+	copy(output_bytes[capacity-len(value_bytes):], value_bytes)
+	return output_bytes
+}
+
+func usesGlobalPalette(g *gif.GIF, frame *image.Paletted) bool {
+	/*
+		Check whether a frame uses a GIF's global colour table. The decoder gives such
+		frames a copy of it, with the frame's transparent colour (if any) cleared.
+	*/
+	global, ok := g.Config.ColorModel.(color.Palette)
+	if !ok || len(global) != len(frame.Palette) {
+		return false
+	}
+	for i, c := range frame.Palette {
+		if _, _, _, a := c.RGBA(); a != 0 && paletteKey(c) != paletteKey(global[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func permutationPalettes(g *gif.GIF) []color.Palette {
+	/*
+		Palettes that carry data, in the order frames first use them: the global
+		colour table once, then each local one.
+	*/
+	var palettes []color.Palette
+	global_added := false
+	for _, frame := range g.Image {
+		if usesGlobalPalette(g, frame) {
+			if !global_added {
+				palettes = append(palettes, g.Config.ColorModel.(color.Palette))
+				global_added = true
+			}
+			continue
+		}
+		palettes = append(palettes, frame.Palette)
+	}
+	return palettes
+}
+
+func PalettePermutationCapacity(g *gif.GIF) int {
+	capacity := 0
+	for _, palette := range permutationPalettes(g) {
+		capacity += paletteCapacity(palette)
+	}
+	return capacity
+}
+
+func EmbedPalettePermutation(g *gif.GIF, secret []byte) *gif.GIF {
+	/*
+		Hide data in the order of the palettes: the secret, as a number, picks one of
+		the n! orders of a palette's n distinct colours. Pixels are remapped to the
+		reordered palette, so the image looks exactly the same. The payload continues
+		from the global colour table, shared by frames without their own, to each
+		local one.
+	*/
+	capacity := PalettePermutationCapacity(g)
+	if len(secret) > capacity {
+		fmt.Printf("WARNING: Palette too small with given secret -- only %d/%d bytes embedded.\n", capacity, len(secret))
+		secret = secret[:capacity]
+	}
+	new_gif := copyGif(g)
+	remap_frame := func(f int, palette color.Palette, remap []uint8) {
+		frame := g.Image[f]
+		new_palette := append(color.Palette(nil), palette...)
+		for i, c := range frame.Palette {
+			// Keep the frame's transparent colour, at its new index
+			if _, _, _, a := c.RGBA(); a == 0 {
+				new_palette[remap[i]] = c
+			}
+		}
+		new_frame := image.NewPaletted(frame.Bounds(), new_palette)
+		for i, index := range frame.Pix {
+			if int(index) < len(remap) {
+				new_frame.Pix[i] = remap[index]
+			}
+		}
+		new_gif.Image[f] = new_frame
+	}
+
+	secret_pos := 0
+	take := func(palette color.Palette) []byte {
+		end := secret_pos + paletteCapacity(palette)
+		if end > len(secret) {
+			end = len(secret)
+		}
+		chunk := secret[secret_pos:end]
+		secret_pos = end
+		return chunk
+	}
+	var global_palette color.Palette
+	var global_remap []uint8
+	for f, frame := range g.Image {
+		if usesGlobalPalette(g, frame) {
+			if global_remap == nil {
+				global_palette, global_remap = permutePalette(g.Config.ColorModel.(color.Palette), take(g.Config.ColorModel.(color.Palette)))
+				new_gif.Config.ColorModel = global_palette
+			}
+			remap_frame(f, global_palette, global_remap)
+			continue
+		}
+		palette, remap := permutePalette(frame.Palette, take(frame.Palette))
+		remap_frame(f, palette, remap)
+	}
+	return new_gif
+}
+
+func ExtractPalettePermutation(g *gif.GIF) []byte {
+	var output_bytes []byte
+	for _, palette := range permutationPalettes(g) {
+		output_bytes = append(output_bytes, readPalettePermutation(palette)...)
+	}
+	return output_bytes
+}
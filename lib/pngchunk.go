@@ -0,0 +1,258 @@
+package lib
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+var PngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+type PngChunk struct {
+	Type string
+	Data []byte
+}
+
+func ParsePngChunks(data []byte) ([]PngChunk, error) {
+	/*
+		Split PNG file data into its chunks, up to and including IEND.
+		Anything after IEND is ignored.
+	*/
+	if !bytes.HasPrefix(data, PngSignature) {
+		return nil, errors.New("file is not a PNG")
+	}
+	var chunks []PngChunk
+	pos := len(PngSignature)
+	for {
+		if pos+8 > len(data) {
+			return nil, errors.New("PNG ended before IEND chunk")
+		}
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		chunk_type := string(data[pos+4 : pos+8])
+		if pos+12+length > len(data) {
+			return nil, fmt.Errorf("PNG chunk '%s' at offset %d runs past end of file", chunk_type, pos)
+		}
+		chunk_data := data[pos+8 : pos+8+length]
+		crc := binary.BigEndian.Uint32(data[pos+8+length : pos+12+length])
+		if crc != crc32.ChecksumIEEE(data[pos+4:pos+8+length]) {
+			fmt.Printf("WARNING: PNG chunk '%s' at offset %d has an invalid CRC.\n", chunk_type, pos)
+		}
+		chunks = append(chunks, PngChunk{Type: chunk_type, Data: chunk_data})
+		pos += 12 + length
+		if chunk_type == "IEND" {
+			return chunks, nil
+		}
+	}
+}
+
+func OpenPngChunks(image_path string) ([]PngChunk, error) {
+	/*
+		Read a PNG file and split it into chunks
+	*/
+	data, err := ioutil.ReadFile(image_path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePngChunks(data)
+}
+
+func EncodePngChunks(chunks []PngChunk) []byte {
+	/*
+		Join chunks back into PNG file data, recomputing every CRC.
+	*/
+	var buf bytes.Buffer
+	buf.Write(PngSignature)
+	for _, chunk := range chunks {
+		binary.Write(&buf, binary.BigEndian, uint32(len(chunk.Data)))
+		buf.WriteString(chunk.Type)
+		buf.Write(chunk.Data)
+		binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(chunk.Type), chunk.Data...)))
+	}
+	return buf.Bytes()
+}
+
+func IsPngTextChunk(chunk_type string) bool {
+	return chunk_type == "tEXt" || chunk_type == "zTXt" || chunk_type == "iTXt"
+}
+
+func ValidatePngChunkType(chunk_type string) error {
+	/*
+		Check a chunk type can be added to a PNG without breaking decoders: it must be
+		four ASCII letters, ancillary (lowercase first letter) and not use the reserved
+		bit (uppercase third letter).
+	*/
+	if len(chunk_type) != 4 {
+		return fmt.Errorf("invalid chunk type '%s'. Must be four letters", chunk_type)
+	}
+	for i := 0; i < 4; i++ {
+		c := chunk_type[i]
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')) {
+			return fmt.Errorf("invalid chunk type '%s'. Must be four letters", chunk_type)
+		}
+	}
+	if chunk_type[0] < 'a' {
+		return fmt.Errorf("invalid chunk type '%s'. Must be ancillary, i.e., start with a lowercase letter", chunk_type)
+	}
+	if chunk_type[2] >= 'a' {
+		return fmt.Errorf("invalid chunk type '%s'. Third letter must be uppercase", chunk_type)
+	}
+	return nil
+}
+
+func NewPngTextChunk(chunk_type string, keyword string, payload []byte) (PngChunk, error) {
+	/*
+		Build a tEXt, zTXt or iTXt chunk holding payload under keyword.
+		tEXt can't hold NUL bytes, so binary payloads need zTXt or iTXt, which
+		are always compressed here.
+	*/
+	if len(keyword) < 1 || len(keyword) > 79 || bytes.IndexByte([]byte(keyword), 0) != -1 {
+		return PngChunk{}, fmt.Errorf("invalid keyword '%s'. Must be 1-79 characters", keyword)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(keyword)
+	buf.WriteByte(0)
+	switch chunk_type {
+	case "tEXt":
+		if bytes.IndexByte(payload, 0) != -1 {
+			return PngChunk{}, errors.New("tEXt chunks can't hold NUL bytes. Use zTXt or iTXt for binary data")
+		}
+		buf.Write(payload)
+	case "zTXt":
+		// Compression method 0 (zlib)
+		buf.WriteByte(0)
+		buf.Write(zlibCompress(payload))
+	case "iTXt":
+		// Compressed, with zlib, and no language tag or translated keyword
+		buf.Write([]byte{1, 0, 0, 0})
+		buf.Write(zlibCompress(payload))
+	default:
+		return PngChunk{}, fmt.Errorf("invalid text chunk type '%s'. Must be tEXt, zTXt or iTXt", chunk_type)
+	}
+	return PngChunk{Type: chunk_type, Data: buf.Bytes()}, nil
+}
+
+func ReadPngTextChunk(chunk PngChunk) (string, []byte, error) {
+	/*
+		Return the keyword and (decompressed) text of a tEXt, zTXt or iTXt chunk.
+	*/
+	keyword_end := bytes.IndexByte(chunk.Data, 0)
+	if keyword_end == -1 {
+		return "", nil, fmt.Errorf("%s chunk has no keyword", chunk.Type)
+	}
+	keyword := string(chunk.Data[:keyword_end])
+	rest := chunk.Data[keyword_end+1:]
+	switch chunk.Type {
+	case "tEXt":
+		return keyword, rest, nil
+	case "zTXt":
+		if len(rest) < 1 {
+			return keyword, nil, errors.New("zTXt chunk is missing its compression method")
+		}
+		text, err := zlibDecompress(rest[1:])
+		return keyword, text, err
+	case "iTXt":
+		if len(rest) < 2 {
+			return keyword, nil, errors.New("iTXt chunk is missing its compression flags")
+		}
+		compressed := rest[0] == 1
+		// Skip language tag and translated keyword
+		rest = rest[2:]
+		for i := 0; i < 2; i++ {
+			end := bytes.IndexByte(rest, 0)
+			if end == -1 {
+				return keyword, nil, errors.New("iTXt chunk is truncated")
+			}
+			rest = rest[end+1:]
+		}
+		if compressed {
+			text, err := zlibDecompress(rest)
+			return keyword, text, err
+		}
+		return keyword, rest, nil
+	}
+	return "", nil, fmt.Errorf("'%s' is not a text chunk", chunk.Type)
+}
+
+func NewPngExifChunk(chunks []PngChunk, payload []byte) (PngChunk, error) {
+	/*
+		Build an eXIf chunk holding payload in a MakerNote tag. eXIf must hold a
+		TIFF header and IFDs, so the tag is added to any existing EXIF data,
+		or to a minimal new IFD0.
+	*/
+	im, err := exifcommon.NewIfdMappingWithStandard()
+	if err != nil {
+		return PngChunk{}, err
+	}
+	ti := exif.NewTagIndex()
+	var byte_order binary.ByteOrder = exifcommon.EncodeDefaultByteOrder
+	ib := exif.NewIfdBuilder(im, ti, exifcommon.IfdStandardIfdIdentity, byte_order)
+	for _, chunk := range chunks {
+		if chunk.Type != "eXIf" {
+			continue
+		}
+		_, index, err := exif.Collect(im, ti, chunk.Data)
+		if err != nil {
+			return PngChunk{}, fmt.Errorf("existing eXIf chunk is invalid: %v", err)
+		}
+		ib = exif.NewIfdBuilderFromExistingChain(index.RootIfd)
+		byte_order = index.RootIfd.ByteOrder()
+	}
+	err = EmbedExifChunks(ib, byte_order, []string{"MakerNote"}, payload)
+	if err != nil {
+		return PngChunk{}, err
+	}
+	data, err := exif.NewIfdByteEncoder().EncodeToExif(ib)
+	if err != nil {
+		return PngChunk{}, err
+	}
+	return PngChunk{Type: "eXIf", Data: data}, nil
+}
+
+func InsertPngChunk(chunks []PngChunk, chunk PngChunk) []PngChunk {
+	/*
+		Add a chunk to a PNG. eXIf replaces any existing eXIf chunk and goes
+		before the image data, everything else goes just before IEND.
+	*/
+	var new_chunks []PngChunk
+	inserted := false
+	for _, existing := range chunks {
+		if chunk.Type == "eXIf" {
+			if existing.Type == "eXIf" {
+				continue
+			}
+			if existing.Type == "IDAT" && !inserted {
+				new_chunks = append(new_chunks, chunk)
+				inserted = true
+			}
+		} else if existing.Type == "IEND" && !inserted {
+			new_chunks = append(new_chunks, chunk)
+			inserted = true
+		}
+		new_chunks = append(new_chunks, existing)
+	}
+	return new_chunks
+}
+
+func zlibCompress(data []byte) []byte {
+	var buf bytes.Buffer
+	writer := zlib.NewWriter(&buf)
+	writer.Write(data)
+	writer.Close()
+	return buf.Bytes()
+}
+
+func zlibDecompress(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
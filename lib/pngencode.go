@@ -0,0 +1,473 @@
+package lib
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// PNG colour types
+const (
+	PngGray            = 0
+	PngTrueColour      = 2
+	PngPaletted        = 3
+	PngGrayAlpha       = 4
+	PngTrueColourAlpha = 6
+)
+
+var pngColourTypeNames = map[uint8]string{
+	PngGray:            "greyscale",
+	PngTrueColour:      "RGB",
+	PngPaletted:        "paletted",
+	PngGrayAlpha:       "greyscale and alpha",
+	PngTrueColourAlpha: "RGBA",
+}
+
+// Starting column and row, and column and row steps, of the seven Adam7 passes
+var adam7Passes = [][4]int{{0, 0, 8, 8}, {4, 0, 8, 8}, {0, 4, 4, 8}, {2, 0, 4, 4}, {0, 2, 2, 4}, {1, 0, 2, 2}, {0, 1, 1, 2}}
+
+// How a PNG is stored, so that another image can be written the same way
+type PngFormat struct {
+	ColourType       uint8
+	BitDepth         uint8
+	Interlaced       bool
+	CompressionLevel int
+	IdatSize         int
+	// PLTE entries with their tRNS alpha, for paletted images
+	Palette []color.NRGBA
+	// Raw tRNS chunk, for greyscale and RGB images with a transparent colour
+	TransparentKey []byte
+	Chunks         []PngChunk
+}
+
+func PngFormatName(colour_type uint8, bit_depth uint8) string {
+	return fmt.Sprintf("%d-bit %s", bit_depth, pngColourTypeNames[colour_type])
+}
+
+func ReadPngFormat(data []byte) (PngFormat, error) {
+	/*
+		Read the colour type, bit depth, interlacing, palette, compression level and
+		IDAT chunk size of a PNG, along with its chunks.
+	*/
+	var format PngFormat
+	chunks, err := ParsePngChunks(data)
+	if err != nil {
+		return format, err
+	}
+	if chunks[0].Type != "IHDR" || len(chunks[0].Data) != 13 {
+		return format, errors.New("PNG doesn't start with a valid IHDR chunk")
+	}
+	format.Chunks = chunks
+	format.BitDepth = chunks[0].Data[8]
+	format.ColourType = chunks[0].Data[9]
+	format.Interlaced = chunks[0].Data[12] == 1
+	if _, ok := pngColourTypeNames[format.ColourType]; !ok {
+		return format, fmt.Errorf("invalid PNG colour type %d", format.ColourType)
+	}
+	format.CompressionLevel = zlib.DefaultCompression
+	for _, chunk := range chunks {
+		switch chunk.Type {
+		case "PLTE":
+			for i := 0; i+2 < len(chunk.Data); i += 3 {
+				format.Palette = append(format.Palette, color.NRGBA{chunk.Data[i], chunk.Data[i+1], chunk.Data[i+2], 0xff})
+			}
+		case "tRNS":
+			if format.ColourType == PngPaletted {
+				for i, alpha := range chunk.Data {
+					if i < len(format.Palette) {
+						format.Palette[i].A = alpha
+					}
+				}
+			} else {
+				format.TransparentKey = chunk.Data
+			}
+		case "IDAT":
+			if format.IdatSize == 0 && len(chunk.Data) >= 2 {
+				format.IdatSize = len(chunk.Data)
+				// zlib FLEVEL, from the second header byte
+				format.CompressionLevel = []int{zlib.BestSpeed, 5, zlib.DefaultCompression, zlib.BestCompression}[chunk.Data[1]>>6]
+			}
+		}
+	}
+	return format, nil
+}
+
+func nrgba64At(img image.Image, x int, y int) color.NRGBA64 {
+	/*
+		Read a pixel without premultiplying, so the colour of transparent pixels,
+		which may hold embedded bits, isn't lost.
+	*/
+	switch src := img.(type) {
+	case *image.NRGBA:
+		c := src.NRGBAAt(x, y)
+		return color.NRGBA64{uint16(c.R) * 257, uint16(c.G) * 257, uint16(c.B) * 257, uint16(c.A) * 257}
+	case *image.NRGBA64:
+		return src.NRGBA64At(x, y)
+	case *image.Paletted:
+		c := src.Palette[src.ColorIndexAt(x, y)]
+		if nrgba, ok := c.(color.NRGBA); ok {
+			return color.NRGBA64{uint16(nrgba.R) * 257, uint16(nrgba.G) * 257, uint16(nrgba.B) * 257, uint16(nrgba.A) * 257}
+		}
+	}
+	return color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+}
+
+func pngSamples(colour_type uint8, c color.NRGBA64) []uint16 {
+	switch colour_type {
+	case PngGray:
+		return []uint16{c.R}
+	case PngGrayAlpha:
+		return []uint16{c.R, c.A}
+	case PngTrueColour:
+		return []uint16{c.R, c.G, c.B}
+	}
+	return []uint16{c.R, c.G, c.B, c.A}
+}
+
+func pngFits(pixels []color.NRGBA64, colour_type uint8, bit_depth uint8, transparent_key []byte) bool {
+	/*
+		Check every pixel can be stored exactly with a (non-paletted) colour type and
+		bit depth. Greyscale and RGB images can only be transparent through a tRNS
+		colour key, which every fully transparent pixel must match, and which no
+		opaque pixel may match, as it would be shown as transparent.
+	*/
+	step := uint16(65535 / (1<<uint(bit_depth) - 1))
+	var key color.NRGBA64
+	if len(transparent_key) >= 2 {
+		key.R = binary.BigEndian.Uint16(transparent_key) * step
+		key.G, key.B = key.R, key.R
+		if len(transparent_key) >= 6 {
+			key.G = binary.BigEndian.Uint16(transparent_key[2:]) * step
+			key.B = binary.BigEndian.Uint16(transparent_key[4:]) * step
+		}
+	}
+	for _, c := range pixels {
+		if (colour_type == PngGray || colour_type == PngGrayAlpha) && (c.R != c.G || c.R != c.B) {
+			return false
+		}
+		if colour_type == PngGray || colour_type == PngTrueColour {
+			matches_key := transparent_key != nil && c.R == key.R && c.G == key.G && c.B == key.B
+			if c.A != 0xffff && !(c.A == 0 && matches_key) {
+				return false
+			}
+			if c.A == 0xffff && matches_key {
+				return false
+			}
+		}
+		for _, sample := range pngSamples(colour_type, c) {
+			if sample%step != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func pngPaletteIndices(pixels []color.NRGBA64, palette []color.NRGBA, bit_depth uint8) ([]color.NRGBA, []uint8, bool) {
+	/*
+		Map pixels to palette indices, adding any new colours after the existing
+		palette, as long as they all fit in the bit depth.
+	*/
+	palette = append([]color.NRGBA(nil), palette...)
+	lookup := make(map[color.NRGBA]uint8)
+	for i := len(palette) - 1; i >= 0; i-- {
+		lookup[palette[i]] = uint8(i)
+	}
+	indices := make([]uint8, len(pixels))
+	for i, c := range pixels {
+		if c.R%257 != 0 || c.G%257 != 0 || c.B%257 != 0 || c.A%257 != 0 {
+			return nil, nil, false
+		}
+		entry := color.NRGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)}
+		index, ok := lookup[entry]
+		if !ok {
+			if len(palette) >= 1<<uint(bit_depth) {
+				return nil, nil, false
+			}
+			index = uint8(len(palette))
+			lookup[entry] = index
+			palette = append(palette, entry)
+		}
+		indices[i] = index
+	}
+	return palette, indices, true
+}
+
+func pngCandidates(colour_type uint8, bit_depth uint8) [][2]uint8 {
+	/*
+		Colour types and bit depths to try, closest to the cover's first.
+	*/
+	deep := uint8(8)
+	if bit_depth == 16 {
+		deep = 16
+	}
+	candidates := [][2]uint8{{colour_type, bit_depth}}
+	if bit_depth < 8 {
+		candidates = append(candidates, [2]uint8{colour_type, 8})
+	}
+	switch colour_type {
+	case PngGray:
+		candidates = append(candidates, [2]uint8{PngGrayAlpha, deep}, [2]uint8{PngTrueColour, deep}, [2]uint8{PngTrueColourAlpha, deep})
+	case PngTrueColour, PngGrayAlpha:
+		candidates = append(candidates, [2]uint8{PngTrueColourAlpha, deep})
+	case PngPaletted:
+		candidates = append(candidates, [2]uint8{PngTrueColour, 8}, [2]uint8{PngTrueColourAlpha, 8})
+	}
+	return candidates
+}
+
+func pngFilter(row []byte, prev []byte, bytes_per_pixel int, filter_type byte) []byte {
+	filtered := make([]byte, len(row)+1)
+	filtered[0] = filter_type
+	for i, x := range row {
+		var a, b, c byte
+		if i >= bytes_per_pixel {
+			a, c = row[i-bytes_per_pixel], prev[i-bytes_per_pixel]
+		}
+		b = prev[i]
+		switch filter_type {
+		case 0:
+			filtered[i+1] = x
+		case 1:
+			filtered[i+1] = x - a
+		case 2:
+			filtered[i+1] = x - b
+		case 3:
+			filtered[i+1] = x - byte((int(a)+int(b))/2)
+		case 4:
+			// Paeth predictor
+			p := int(a) + int(b) - int(c)
+			pa, pb, pc := Abs(p-int(a)), Abs(p-int(b)), Abs(p-int(c))
+			predictor := c
+			if pa <= pb && pa <= pc {
+				predictor = a
+			} else if pb <= pc {
+				predictor = b
+			}
+			filtered[i+1] = x - predictor
+		}
+	}
+	return filtered
+}
+
+func EncodePngLike(w io.Writer, img image.Image, format PngFormat) error {
+	/*
+		Encode an image as a PNG stored like another: same colour type, bit depth,
+		interlacing, compression level and IDAT chunk size, with the other PNG's
+		ancillary chunks kept in place. If the pixels can't be stored exactly with the
+		same colour type and bit depth, the closest that can is used with a warning.
+	*/
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	pixels := make([]color.NRGBA64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixels[y*width+x] = nrgba64At(img, bounds.Min.X+x, bounds.Min.Y+y)
+		}
+	}
+
+	// Choose colour type and bit depth
+	var colour_type, bit_depth uint8
+	var palette []color.NRGBA
+	var indices []uint8
+	for _, candidate := range pngCandidates(format.ColourType, format.BitDepth) {
+		if src, ok := img.(*image.Paletted); ok && candidate[0] == PngPaletted && len(src.Palette) <= 1<<candidate[1] {
+			// Keep the image's own palette and indices, whose order may matter
+			palette, indices = make([]color.NRGBA, len(src.Palette)), make([]uint8, width*height)
+			for i, c := range src.Palette {
+				c64 := paletteKey(c)
+				palette[i] = color.NRGBA{uint8(c64.R >> 8), uint8(c64.G >> 8), uint8(c64.B >> 8), uint8(c64.A >> 8)}
+			}
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					indices[y*width+x] = src.ColorIndexAt(bounds.Min.X+x, bounds.Min.Y+y)
+				}
+			}
+		} else if candidate[0] == PngPaletted {
+			var ok bool
+			if palette, indices, ok = pngPaletteIndices(pixels, format.Palette, candidate[1]); !ok {
+				continue
+			}
+		} else {
+			var key []byte
+			if candidate[0] == format.ColourType && candidate[1] == format.BitDepth {
+				key = format.TransparentKey
+			}
+			if !pngFits(pixels, candidate[0], candidate[1], key) {
+				continue
+			}
+		}
+		colour_type, bit_depth = candidate[0], candidate[1]
+		break
+	}
+	if bit_depth == 0 {
+		return errors.New("image can't be stored as a PNG")
+	}
+	if colour_type != format.ColourType || bit_depth != format.BitDepth {
+		fmt.Printf("WARNING: Cover is a %s PNG, but the output can't be stored that way. Writing a %s PNG instead.\n", PngFormatName(format.ColourType, format.BitDepth), PngFormatName(colour_type, bit_depth))
+	} else if len(palette) > len(format.Palette) {
+		fmt.Printf("WARNING: Output adds %d colours to the cover's palette.\n", len(palette)-len(format.Palette))
+	}
+	same_format := colour_type == format.ColourType && bit_depth == format.BitDepth
+
+	// Filter scanlines, pass by pass if interlaced. Like libpng, paletted and
+	// low bit depth images aren't filtered, and others use whichever filter gives
+	// the smallest sum of absolute differences.
+	passes := [][4]int{{0, 0, 1, 1}}
+	if format.Interlaced {
+		passes = adam7Passes
+	}
+	channels := len(pngSamples(colour_type, color.NRGBA64{}))
+	if colour_type == PngPaletted {
+		channels = 1
+	}
+	bits_per_pixel := channels * int(bit_depth)
+	bytes_per_pixel := (bits_per_pixel + 7) / 8
+	var raw bytes.Buffer
+	for _, pass := range passes {
+		pass_width := (width - pass[0] + pass[2] - 1) / pass[2]
+		pass_height := (height - pass[1] + pass[3] - 1) / pass[3]
+		if pass_width <= 0 || pass_height <= 0 {
+			continue
+		}
+		prev := make([]byte, (pass_width*bits_per_pixel+7)/8)
+		for py := 0; py < pass_height; py++ {
+			row := make([]byte, len(prev))
+			for px := 0; px < pass_width; px++ {
+				i := (pass[1]+py*pass[3])*width + pass[0] + px*pass[2]
+				var samples []uint16
+				if colour_type == PngPaletted {
+					samples = []uint16{uint16(indices[i])}
+				} else {
+					samples = pngSamples(colour_type, pixels[i])
+				}
+				for s, sample := range samples {
+					if colour_type != PngPaletted {
+						// Reduce 16-bit samples to the bit depth
+						sample /= uint16(65535 / (1<<uint(bit_depth) - 1))
+					}
+					switch bit_depth {
+					case 16:
+						binary.BigEndian.PutUint16(row[(px*channels+s)*2:], sample)
+					case 8:
+						row[px*channels+s] = uint8(sample)
+					default:
+						bit := px * int(bit_depth)
+						row[bit/8] |= uint8(sample) << uint(8-int(bit_depth)-bit%8)
+					}
+				}
+			}
+			best := pngFilter(row, prev, bytes_per_pixel, 0)
+			if colour_type != PngPaletted && bit_depth >= 8 {
+				best_sum := -1
+				for filter_type := byte(0); filter_type <= 4; filter_type++ {
+					filtered := pngFilter(row, prev, bytes_per_pixel, filter_type)
+					sum := 0
+					for _, b := range filtered[1:] {
+						sum += Abs(int(int8(b)))
+					}
+					if best_sum == -1 || sum < best_sum {
+						best, best_sum = filtered, sum
+					}
+				}
+			}
+			raw.Write(best)
+			prev = row
+		}
+	}
+	var compressed bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&compressed, format.CompressionLevel)
+	if err != nil {
+		return err
+	}
+	zw.Write(raw.Bytes())
+	zw.Close()
+
+	// Chunks replacing the cover's image data
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8], ihdr[9] = bit_depth, colour_type
+	if format.Interlaced {
+		ihdr[12] = 1
+	}
+	var plte, trns []byte
+	if colour_type == PngPaletted {
+		last_alpha := 0
+		for i, entry := range palette {
+			plte = append(plte, entry.R, entry.G, entry.B)
+			trns = append(trns, entry.A)
+			if entry.A != 0xff {
+				last_alpha = i + 1
+			}
+		}
+		trns = trns[:last_alpha]
+	} else if same_format {
+		trns = format.TransparentKey
+	}
+	idat_size := format.IdatSize
+	if idat_size <= 0 {
+		idat_size = compressed.Len()
+	}
+
+	// Keep the cover's chunks in place, except those that depend on its colour type
+	var chunks []PngChunk
+	plte_written, trns_written, idat_written := plte == nil, len(trns) == 0, false
+	for _, chunk := range format.Chunks {
+		switch chunk.Type {
+		case "IHDR":
+			chunks = append(chunks, PngChunk{Type: "IHDR", Data: ihdr})
+		case "PLTE":
+			if !plte_written {
+				chunks = append(chunks, PngChunk{Type: "PLTE", Data: plte})
+				plte_written = true
+			} else if same_format && colour_type != PngPaletted {
+				// Suggested palette of an RGB image
+				chunks = append(chunks, chunk)
+			}
+		case "tRNS":
+			if !trns_written && plte_written {
+				chunks = append(chunks, PngChunk{Type: "tRNS", Data: trns})
+				trns_written = true
+			}
+		case "bKGD", "sBIT":
+			if same_format {
+				chunks = append(chunks, chunk)
+			}
+		case "hIST":
+			if same_format && len(palette) == len(format.Palette) {
+				chunks = append(chunks, chunk)
+			}
+		case "IDAT":
+			if idat_written {
+				continue
+			}
+			if !plte_written {
+				chunks = append(chunks, PngChunk{Type: "PLTE", Data: plte})
+				plte_written = true
+			}
+			if !trns_written {
+				chunks = append(chunks, PngChunk{Type: "tRNS", Data: trns})
+				trns_written = true
+			}
+			data := compressed.Bytes()
+			for start := 0; start < len(data); start += idat_size {
+				end := start + idat_size
+				if end > len(data) {
+					end = len(data)
+				}
+				chunks = append(chunks, PngChunk{Type: "IDAT", Data: data[start:end]})
+			}
+			idat_written = true
+		default:
+			chunks = append(chunks, chunk)
+		}
+	}
+	_, err = w.Write(EncodePngChunks(chunks))
+	return err
+}
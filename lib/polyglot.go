@@ -0,0 +1,220 @@
+package lib
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"time"
+)
+
+// ZIP record signatures
+var (
+	zipLocalHeader      = []byte("PK\x03\x04")
+	zipCentralHeader    = []byte("PK\x01\x02")
+	zipEndOfDirectory   = []byte("PK\x05\x06")
+	zipEnd64OfDirectory = []byte("PK\x06\x06")
+	zipEnd64Locator     = []byte("PK\x06\x07")
+)
+
+// An entry of a ZIP archive found in a file
+type ZipEntry struct {
+	Name             string
+	CompressedSize   uint64
+	UncompressedSize uint64
+	Modified         time.Time
+}
+
+// What a file holds when it's both an image and a ZIP archive
+type ZipPolyglotInfo struct {
+	ImageFormat string
+	ImageEnd    int
+	// Offset of the archive's first local header, and of its central directory
+	ArchiveStart     int
+	CentralDirectory int
+	// Whether the archive's offsets are relative to its own start, not the file's
+	Unadjusted bool
+	Entries    []ZipEntry
+}
+
+func findZipEnd(data []byte) (int, error) {
+	/*
+		Find the end of central directory record, searching back from the end of
+		the data past at most a maximum-length comment.
+	*/
+	for pos := len(data) - 22; pos >= 0 && pos >= len(data)-22-0xffff; pos-- {
+		if bytes.Equal(data[pos:pos+4], zipEndOfDirectory) && pos+22+int(binary.LittleEndian.Uint16(data[pos+20:])) <= len(data) {
+			return pos, nil
+		}
+	}
+	return 0, errors.New("no ZIP end of central directory record found")
+}
+
+func shiftZipOffsets(zip_data []byte, shift int) ([]byte, error) {
+	/*
+		Copy a ZIP archive with every absolute offset moved by shift bytes, as if
+		shift bytes had been put before it: local header offsets in the central
+		directory, the central directory's offset, and their ZIP64 versions.
+	*/
+	data := append([]byte(nil), zip_data...)
+	end, err := findZipEnd(data)
+	if err != nil {
+		return nil, err
+	}
+	directory_offset := uint64(binary.LittleEndian.Uint32(data[end+16:]))
+	entries := uint64(binary.LittleEndian.Uint16(data[end+10:]))
+
+	// ZIP64 records come just before the end record
+	zip64 := end >= 20 && bytes.Equal(data[end-20:end-16], zipEnd64Locator)
+	if zip64 {
+		record_offset := binary.LittleEndian.Uint64(data[end-12:])
+		if record_offset >= uint64(len(data)) || uint64(len(data))-record_offset < 56 || !bytes.Equal(data[record_offset:record_offset+4], zipEnd64OfDirectory) {
+			return nil, errors.New("invalid ZIP64 end of central directory record")
+		}
+		binary.LittleEndian.PutUint64(data[end-12:], record_offset+uint64(shift))
+		entries = binary.LittleEndian.Uint64(data[record_offset+32:])
+		directory_offset = binary.LittleEndian.Uint64(data[record_offset+48:])
+		binary.LittleEndian.PutUint64(data[record_offset+48:], directory_offset+uint64(shift))
+	}
+	if directory_offset >= uint64(len(data)) {
+		return nil, errors.New("invalid ZIP central directory offset")
+	}
+	if binary.LittleEndian.Uint32(data[end+16:]) != 0xffffffff {
+		if directory_offset+uint64(shift) < 0xffffffff {
+			binary.LittleEndian.PutUint32(data[end+16:], uint32(directory_offset)+uint32(shift))
+		} else if zip64 {
+			// Too far for 32 bits, so leave readers to use the ZIP64 record
+			binary.LittleEndian.PutUint32(data[end+16:], 0xffffffff)
+		} else {
+			return nil, errors.New("combined file too large for a ZIP without ZIP64 records")
+		}
+	}
+
+	// Central directory entries
+	pos := int(directory_offset)
+	for i := uint64(0); i < entries; i++ {
+		if pos+46 > len(data) || !bytes.Equal(data[pos:pos+4], zipCentralHeader) {
+			return nil, errors.New("invalid ZIP central directory entry")
+		}
+		name_length := int(binary.LittleEndian.Uint16(data[pos+28:]))
+		extra_length := int(binary.LittleEndian.Uint16(data[pos+30:]))
+		comment_length := int(binary.LittleEndian.Uint16(data[pos+32:]))
+		if pos+46+name_length+extra_length+comment_length > len(data) {
+			return nil, errors.New("invalid ZIP central directory entry")
+		}
+		header_offset := binary.LittleEndian.Uint32(data[pos+42:])
+		if header_offset != 0xffffffff {
+			if uint64(header_offset)+uint64(shift) >= 0xffffffff {
+				return nil, errors.New("combined file too large for a ZIP without ZIP64 records")
+			}
+			binary.LittleEndian.PutUint32(data[pos+42:], header_offset+uint32(shift))
+		} else {
+			// The offset is in the ZIP64 extra field, after any sizes also stored there
+			extra := data[pos+46+name_length : pos+46+name_length+extra_length]
+			for len(extra) >= 4 {
+				id, size := binary.LittleEndian.Uint16(extra), int(binary.LittleEndian.Uint16(extra[2:]))
+				if 4+size > len(extra) {
+					break
+				}
+				if id == 1 {
+					field := 4
+					if binary.LittleEndian.Uint32(data[pos+24:]) == 0xffffffff {
+						field += 8
+					}
+					if binary.LittleEndian.Uint32(data[pos+20:]) == 0xffffffff {
+						field += 8
+					}
+					if field+8 <= 4+size {
+						binary.LittleEndian.PutUint64(extra[field:], binary.LittleEndian.Uint64(extra[field:])+uint64(shift))
+					}
+				}
+				extra = extra[4+size:]
+			}
+		}
+		pos += 46 + name_length + extra_length + comment_length
+	}
+	return data, nil
+}
+
+func MakeZipPolyglot(image_data []byte, zip_data []byte) ([]byte, error) {
+	/*
+		Combine an image and a ZIP archive into one file that's valid as both. The
+		archive goes after the end of the image, which image decoders ignore,
+		and its offsets are moved to match, so ZIP tools read it without
+		complaint, as they find the central directory from the end of the file.
+	*/
+	if !bytes.HasPrefix(zip_data, zipLocalHeader) && !bytes.HasPrefix(zip_data, zipEndOfDirectory) {
+		return nil, errors.New("archive is not a ZIP")
+	}
+	end, err := FindImageEnd(image_data)
+	if err != nil {
+		return nil, err
+	}
+	if end < len(image_data) {
+		fmt.Printf("WARNING: Replacing %d bytes already trailing the image.\n", len(image_data)-end)
+	}
+	shifted, err := shiftZipOffsets(zip_data, end)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(image_data[:end])
+	buf.Write(shifted)
+	output := buf.Bytes()
+
+	// Check both readers accept the result
+	if _, _, err := image.DecodeConfig(bytes.NewReader(output)); err != nil {
+		return nil, fmt.Errorf("combined file is no longer a valid image: %v", err)
+	}
+	if _, err := zip.NewReader(bytes.NewReader(output), int64(len(output))); err != nil {
+		return nil, fmt.Errorf("combined file is not a valid ZIP: %v", err)
+	}
+	return output, nil
+}
+
+func DetectZipPolyglot(data []byte) (*ZipPolyglotInfo, error) {
+	/*
+		Check whether an image also parses as a ZIP archive, returning where the
+		archive is and its entries, or nil if it isn't one.
+	*/
+	image_end, err := FindImageEnd(data)
+	if err != nil {
+		return nil, err
+	}
+	info := &ZipPolyglotInfo{ImageFormat: DetectFileType(data), ImageEnd: image_end}
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil
+	}
+
+	// Locate the archive. If the ZIP was appended without moving its offsets,
+	// they are off by where it starts, as ZIP readers allow for
+	end, err := findZipEnd(data)
+	if err != nil {
+		return nil, nil
+	}
+	directory_size := int(binary.LittleEndian.Uint32(data[end+12:]))
+	directory_offset := int(binary.LittleEndian.Uint32(data[end+16:]))
+	base := 0
+	zip64 := end >= 20 && bytes.Equal(data[end-20:end-16], zipEnd64Locator)
+	if !zip64 && end-directory_size-directory_offset > 0 {
+		base = end - directory_size - directory_offset
+		info.Unadjusted = true
+	}
+	info.CentralDirectory = base + directory_offset
+	info.ArchiveStart = info.CentralDirectory
+	pos := info.CentralDirectory
+	for pos+46 <= len(data) && bytes.Equal(data[pos:pos+4], zipCentralHeader) {
+		header_offset := binary.LittleEndian.Uint32(data[pos+42:])
+		if header_offset != 0xffffffff && base+int(header_offset) < info.ArchiveStart {
+			info.ArchiveStart = base + int(header_offset)
+		}
+		pos += 46 + int(binary.LittleEndian.Uint16(data[pos+28:])) + int(binary.LittleEndian.Uint16(data[pos+30:])) + int(binary.LittleEndian.Uint16(data[pos+32:]))
+	}
+	for _, f := range reader.File {
+		info.Entries = append(info.Entries, ZipEntry{f.Name, f.CompressedSize64, f.UncompressedSize64, f.Modified})
+	}
+	return info, nil
+}
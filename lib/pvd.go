@@ -4,16 +4,42 @@ import (
 	"errors"
 	"fmt"
 	"image"
-	"image/draw"
 	"math"
 	"strconv"
 	"strings"
 )
 
+// Named range width presets, from the Wu-Tsai paper and either side of it
+var PvdRangePresets = map[string][]string{
+	"wu-tsai": {"8", "8", "16", "32", "64", "128"},
+	"narrow":  {"2", "2", "4", "4", "4", "8", "8", "16", "16", "32", "32", "64", "64"},
+	"wide":    {"16", "16", "32", "64", "128"},
+}
+
+// Preset names in the order they are tried
+var PvdRangePresetNames = []string{"wu-tsai", "narrow", "wide"}
+
+func RangeWidthsFromArgs(args []string) []string {
+	/*
+		Resolve range width arguments, which are either a list of widths, a single
+		preset name, or empty for the Wu-Tsai default.
+	*/
+	if len(args) == 0 {
+		return PvdRangePresets["wu-tsai"]
+	}
+	if len(args) == 1 {
+		if preset, ok := PvdRangePresets[args[0]]; ok {
+			return preset
+		}
+	}
+	return args
+}
+
 func CreateRangeTableArray(range_widths []string) ([][]int, error) {
 	/*
 		Convert a string of ranges to a quantization range table array.
 		i.e., {"8", "8", "16", "32", "64", "128"} -> [(0, 7), (8, 15), (16, 31), (32, 63), (64, 127), (128, 255)]
+		Every width must be a power of two, and the widths must cover 0-255 exactly.
 	*/
 	var range_table = make([][]int, len(range_widths))
 	start := 0
@@ -24,10 +50,19 @@ func CreateRangeTableArray(range_widths []string) ([][]int, error) {
 			err_msg := fmt.Sprintf("invalid range width item '%s' given", range_str)
 			return range_table, errors.New(err_msg)
 		}
+		if range_int < 1 || range_int&(range_int-1) != 0 {
+			return range_table, fmt.Errorf("invalid range width '%d' at position %d. Must be a power of two (1, 2, 4, ..., 256)", range_int, index+1)
+		}
+		if start+range_int > 256 {
+			return range_table, fmt.Errorf("range width '%d' at position %d takes the table to %d. Widths must add up to exactly 256", range_int, index+1, start+range_int)
+		}
 		// Add start and end position to range table
 		range_table[index] = []int{start, start + range_int - 1}
 		start += range_int
 	}
+	if start != 256 {
+		return range_table, fmt.Errorf("range widths add up to %d, leaving differences %d-255 uncovered. Widths must add up to exactly 256", start, start)
+	}
 	return range_table, nil
 }
 
@@ -45,232 +80,328 @@ func checkRangeTable(range_table [][]int, pixel_difference int) (int, int) {
 	return 0, 0
 }
 
+func nextBits(secret_bits string, position int, bit_count int) string {
+	/*
+		Take the next bit_count bits of a binstring, padding the final chunk
+		with zeros so that it extracts back in order.
+	*/
+	if position+bit_count <= len(secret_bits) {
+		return secret_bits[position : position+bit_count]
+	}
+	if position >= len(secret_bits) {
+		return strings.Repeat("0", bit_count)
+	}
+	return secret_bits[position:] + strings.Repeat("0", bit_count-len(secret_bits[position:]))
+}
+
+func embedPvdPair(prev_val int, curr_val int, range_table [][]int, secret_bits string, position int, legacy bool) (int, int, int) {
+	/*
+		Embed the next bits into a pixel pair by changing their difference,
+		returning the new pixel values and the number of bits embedded.
+		In legacy mode the final chunk isn't padded, as plain PVD has always done.
+	*/
+	// Get difference between current pixel and previous pixel
+	pixel_difference := prev_val - curr_val
+	// Find minimum range and number of embeddable bits using range table
+	min_range, bit_count := checkRangeTable(range_table, Abs(pixel_difference))
+	// Calculate what data to embed
+	bits_to_embed := nextBits(secret_bits, position, bit_count)
+	if legacy && position+bit_count > len(secret_bits) {
+		bits_to_embed = secret_bits[position:]
+	}
+	int_to_embed, _ := strconv.ParseInt(bits_to_embed, 2, 64)
+	// Find new difference to put between pixels
+	new_pixel_difference := min_range + int(int_to_embed)
+	// Change pixel values to fit new difference
+	var m float64
+	if pixel_difference < 0 {
+		m = float64(pixel_difference - (new_pixel_difference * -1))
+	} else {
+		m = float64(pixel_difference - new_pixel_difference)
+	}
+	m /= 2
+
+	if pixel_difference%2 == 0 {
+		prev_val -= int(math.Floor(m))
+		curr_val += int(math.Ceil(m))
+	} else {
+		prev_val -= int(math.Ceil(m))
+		curr_val += int(math.Floor(m))
+	}
+
+	// Fix overflowing values
+	if prev_val < 0 {
+		curr_val += prev_val * -1
+		prev_val = 0
+	} else if curr_val < 0 {
+		prev_val += curr_val * -1
+		curr_val = 0
+	} else if prev_val > 255 {
+		curr_val -= (prev_val - 255)
+		prev_val = 255
+	} else if curr_val > 255 {
+		prev_val -= (curr_val - 255)
+		curr_val = 255
+	}
+	return prev_val, curr_val, bit_count
+}
+
+func embedLsbPair(prev_val int, curr_val int, lsb_threshold int, lsb_bits int, secret_bits string, position int) (int, int, int) {
+	/*
+		Embed the next 2*lsb_bits bits into the low bits of a smooth pixel pair,
+		then readjust the pair (keeping its low bits) so that its difference
+		stays at or below the threshold.
+	*/
+	mask := (1 << lsb_bits) - 1
+	// Replace the low bits as LSB embedding does, most significant first
+	bits := nextBits(secret_bits, position, 2*lsb_bits)
+	prev_lsb, curr_lsb := uint8(prev_val), uint8(curr_val)
+	for i := 0; i < lsb_bits; i++ {
+		prev_lsb = setBit(prev_lsb, lsb_bits-1-i, bits[i] == '1')
+		curr_lsb = setBit(curr_lsb, lsb_bits-1-i, bits[lsb_bits+i] == '1')
+	}
+	new_prev, new_curr := int(prev_lsb), int(curr_lsb)
+
+	// Find the closest readjustment that keeps the pair smooth
+	best_prev, best_curr, best_distortion := new_prev, new_curr, -1
+	for _, prev_step := range []int{0, -1, 1} {
+		for _, curr_step := range []int{0, -1, 1} {
+			candidate_prev := new_prev + prev_step*(mask+1)
+			candidate_curr := new_curr + curr_step*(mask+1)
+			if candidate_prev < 0 || candidate_prev > 255 || candidate_curr < 0 || candidate_curr > 255 {
+				continue
+			}
+			if Abs(candidate_prev-candidate_curr) > lsb_threshold {
+				continue
+			}
+			distortion := Abs(candidate_prev-prev_val) + Abs(candidate_curr-curr_val)
+			if best_distortion == -1 || distortion < best_distortion {
+				best_prev, best_curr, best_distortion = candidate_prev, candidate_curr, distortion
+			}
+		}
+	}
+	return best_prev, best_curr, 2 * lsb_bits
+}
+
+func extractLsbPair(prev_val int, curr_val int, lsb_bits int) string {
+	/*
+		Read back the bits embedded with embedLsbPair.
+	*/
+	mask := (1 << lsb_bits) - 1
+	prev_binary := ZeroLeftPad(strconv.FormatInt(int64(prev_val&mask), 2), lsb_bits)
+	curr_binary := ZeroLeftPad(strconv.FormatInt(int64(curr_val&mask), 2), lsb_bits)
+	return prev_binary + curr_binary
+}
+
 func EmbedPvd(cover_img image.Image, range_table [][]int, secret_bits string, direction string, zigzag bool, plane string) (image.Image, error) {
 	/*
 		Embed a binstring ("11001011") into an image from a given range table, in
 		either "row" or "column" direction, optionally in zigzag pattern.
 	*/
-	// Get R/G/B/A plane if given
-	rgba_index, err := RgbaToInt(plane)
+	return embedPvdPairs(cover_img, range_table, secret_bits, direction, zigzag, plane, 0, 0, true)
+}
+
+func EmbedPvdLsb(cover_img image.Image, range_table [][]int, secret_bits string, lsb_threshold int, lsb_bits int, direction string, zigzag bool, plane string) (image.Image, error) {
+	/*
+		Embed a binstring using the Wu et al. PVD and LSB replacement hybrid.
+		Pairs with a difference at or below lsb_threshold carry lsb_bits in each
+		pixel, while all other pairs are embedded with PVD from the range table.
+	*/
+	err := checkLsbThreshold(range_table, lsb_threshold, lsb_bits)
 	if err != nil {
 		return nil, err
 	}
+	return embedPvdPairs(cover_img, range_table, secret_bits, direction, zigzag, plane, lsb_threshold, lsb_bits, false)
+}
 
-	// Get image details and create new type based off given input
-	bounds := cover_img.Bounds()
-	gray_img := image.NewGray(bounds)
-	rgba_img := image.NewRGBA(bounds)
-	width, height := bounds.Max.X, bounds.Max.Y
-	draw.Draw(gray_img, bounds, cover_img, bounds.Min, draw.Src)
-	draw.Draw(rgba_img, bounds, cover_img, bounds.Min, draw.Src)
+func checkLsbThreshold(range_table [][]int, lsb_threshold int, lsb_bits int) error {
+	/*
+		Ensure a hybrid threshold keeps smooth and edge pairs apart: PVD must never move
+		a pair across the threshold, and LSB pairs must be able to readjust below it.
+	*/
+	if lsb_bits < 1 || lsb_bits > 7 {
+		return fmt.Errorf("invalid LSB bit count '%d'. Must be an int between 1-7", lsb_bits)
+	}
+	if 1<<lsb_bits > lsb_threshold+1 {
+		return fmt.Errorf("LSB threshold '%d' is too small for %d LSB bits. Must be at least %d", lsb_threshold, lsb_bits, (1<<lsb_bits)-1)
+	}
+	for _, _range := range range_table {
+		if _range[1] == lsb_threshold {
+			return nil
+		}
+	}
+	return fmt.Errorf("LSB threshold '%d' must be the upper end of a range in the range table", lsb_threshold)
+}
 
-	// Check number of values per pixel in image
-	values_per_pixel, err := GetValuesPerPixel(cover_img)
+func LsbThresholdFromTable(range_table [][]int, lsb_bits int) int {
+	/*
+		Pick a hybrid threshold for a range table: the end of the first range
+		that's wide enough for lsb_bits to be readjusted below it.
+	*/
+	for _, _range := range range_table {
+		if _range[1] >= (1<<lsb_bits)-1 {
+			return _range[1]
+		}
+	}
+	return 255
+}
+
+func embedPvdPairs(cover_img image.Image, range_table [][]int, secret_bits string, direction string, zigzag bool, plane string, lsb_threshold int, lsb_bits int, legacy bool) (image.Image, error) {
+	/*
+		Walk the image in pixel pairs and embed into each, using LSB replacement for
+		smooth pairs if lsb_bits is non-zero and PVD otherwise. Legacy mode keeps
+		plain PVD's bitstream unchanged, so its images still extract.
+	*/
+	// Get image details and create new type based off given input
+	new_img, pix_arr, values_per_pixel, err := newCanvas(cover_img)
 	if err != nil {
 		return nil, err
 	}
-	var pix_arr []uint8
-	if values_per_pixel == 1 {
-		pix_arr = gray_img.Pix
-	} else {
-		pix_arr = rgba_img.Pix
+
+	// Get R/G/B/A planes if given
+	channels, err := pvdChannels(plane, values_per_pixel)
+	if err != nil {
+		return nil, err
 	}
+	bounds := cover_img.Bounds()
+	width, height := bounds.Max.X, bounds.Max.Y
 
 	// Vars for keeping track of state between pixels
-	first_in_pair := true
-	previous_index := -1
+	previous_pixel := -1
 	secret_position := 0
 
 	// Iterate through pixels in given order, based off: https://gist.github.com/Ge0rg3/282dd5671d755acbf13352a7ae8e2d5e
-	start_a, start_b := 0, 0
-	step_a, step_b := 1, 1
-	end_a, end_b := height, width
-	if direction != "row" {
-		end_a, end_b = width, height
-	}
-	for a := start_a; a < end_a; a += step_a {
-		// Flip direction of row/col if in zigzag pattern
-		if zigzag {
-			if a%2 == 1 {
-				start_b = end_b - 1
-				end_b, step_b = -1, -1
-			} else {
-				start_b = 0
-				step_b = 1
-				end_b = width
-				if direction != "row" {
-					end_b = height
-				}
-			}
+	for _, pixel := range pixelOrder(width, height, direction, zigzag) {
+		// If first in pixel pair, continue to next
+		if previous_pixel == -1 {
+			previous_pixel = pixel
+			continue
 		}
-		for b := start_b; b != end_b; b += step_b {
-			var index int
-			if direction == "row" {
-				index = ((a*width)+b)*values_per_pixel + rgba_index
+		// Embed in each channel of the pair, in the order given
+		for _, channel := range channels {
+			previous_index := pvdPixelOffset(previous_pixel, width, values_per_pixel, direction, legacy) + channel
+			index := pvdPixelOffset(pixel, width, values_per_pixel, direction, legacy) + channel
+			prev_val, curr_val := int(pix_arr[previous_index]), int(pix_arr[index])
+			var bit_count int
+			if lsb_bits > 0 && Abs(prev_val-curr_val) <= lsb_threshold {
+				prev_val, curr_val, bit_count = embedLsbPair(prev_val, curr_val, lsb_threshold, lsb_bits, secret_bits, secret_position)
 			} else {
-				index = (b * width * values_per_pixel) + a + rgba_index
+				prev_val, curr_val, bit_count = embedPvdPair(prev_val, curr_val, range_table, secret_bits, secret_position, legacy)
 			}
-			// Iteration process now complete. PVD logic per pixel now starts
-			if first_in_pair {
-				// If first in pixel pair, continue to next
-				previous_index = index
-				first_in_pair = false
-			} else {
-				// Get difference between current pixel and previous pixel
-				pixel_difference := int(pix_arr[previous_index]) - int(pix_arr[index])
-				// Find minimum range and number of embeddable bits using range table
-				min_range, bit_count := checkRangeTable(range_table, Abs(pixel_difference))
-				// Calculate what data to embed
-				var bits_to_embed string
-				if secret_position+bit_count <= len(secret_bits) {
-					bits_to_embed = secret_bits[secret_position : secret_position+bit_count]
-				} else {
-					bits_to_embed = secret_bits[secret_position:]
-				}
-				int_to_embed, _ := strconv.ParseInt(bits_to_embed, 2, 64)
-				// Find new difference to put between pixels
-				new_pixel_difference := min_range + int(int_to_embed)
-				// Change pixel values to fit new difference
-				var m float64
-				if pixel_difference < 0 {
-					m = float64(pixel_difference - (new_pixel_difference * -1))
-				} else {
-					m = float64(pixel_difference - new_pixel_difference)
-				}
-				m /= 2
-
-				prev_val := int(pix_arr[previous_index])
-				curr_val := int(pix_arr[index])
-				if pixel_difference%2 == 0 {
-					prev_val -= int(math.Floor(m))
-					curr_val += int(math.Ceil(m))
-				} else {
-					prev_val -= int(math.Ceil(m))
-					curr_val += int(math.Floor(m))
-				}
+			pix_arr[previous_index] = uint8(prev_val)
+			pix_arr[index] = uint8(curr_val)
 
-				// Fix overflowing values
-				if prev_val < 0 {
-					curr_val += prev_val * -1
-					prev_val = 0
-				} else if curr_val < 0 {
-					prev_val += curr_val * -1
-					curr_val = 0
-				} else if prev_val > 255 {
-					curr_val -= (prev_val - 255)
-					prev_val = 255
-				} else if curr_val > 255 {
-					prev_val -= (curr_val - 255)
-					curr_val = 255
-				}
-
-				pix_arr[previous_index] = uint8(prev_val)
-				pix_arr[index] = uint8(curr_val)
-
-				secret_position += bit_count
-				first_in_pair = true
-			}
+			secret_position += bit_count
 			if secret_position >= len(secret_bits) {
-				if values_per_pixel == 1 {
-					return gray_img, nil
-				} else {
-					return rgba_img, nil
-				}
+				return new_img, nil
 			}
-
 		}
+		previous_pixel = -1
 	}
 	fmt.Printf("WARNING: Image too small with given secret -- only %d/%d bits embedded.\n", secret_position, len(secret_bits))
-	if values_per_pixel == 1 {
-		return gray_img, nil
-	} else {
-		return rgba_img, nil
-	}
+	return new_img, nil
 }
 
 func ExtractPvd(img image.Image, range_table [][]int, direction string, zigzag bool, plane string) ([]byte, error) {
-	// Get R/G/B/A plane if given
-	rgba_index, err := RgbaToInt(plane)
+	return extractPvdPairs(img, range_table, direction, zigzag, plane, 0, 0, true)
+}
+
+func ExtractPvdLsb(img image.Image, range_table [][]int, lsb_threshold int, lsb_bits int, direction string, zigzag bool, plane string) ([]byte, error) {
+	err := checkLsbThreshold(range_table, lsb_threshold, lsb_bits)
 	if err != nil {
 		return nil, err
 	}
+	return extractPvdPairs(img, range_table, direction, zigzag, plane, lsb_threshold, lsb_bits, false)
+}
 
+func extractPvdPairs(img image.Image, range_table [][]int, direction string, zigzag bool, plane string, lsb_threshold int, lsb_bits int, legacy bool) ([]byte, error) {
 	// Get image details
-	bounds := img.Bounds()
-	width, height := bounds.Max.X, bounds.Max.Y
-
-	// Check number of values per pixel in image
-	var pix_arr []uint8
-	values_per_pixel, err := GetValuesPerPixel(img)
+	_, pix_arr, values_per_pixel, err := newCanvas(img)
 	if err != nil {
 		return nil, err
 	}
-	if values_per_pixel == 1 {
-		gray_img := image.NewGray(bounds)
-		draw.Draw(gray_img, bounds, img, bounds.Min, draw.Src)
-		pix_arr = gray_img.Pix
-	} else {
-		rgba_img := image.NewRGBA(bounds)
-		draw.Draw(rgba_img, bounds, img, bounds.Min, draw.Src)
-		pix_arr = rgba_img.Pix
+
+	// Get R/G/B/A planes if given
+	channels, err := pvdChannels(plane, values_per_pixel)
+	if err != nil {
+		return nil, err
 	}
+	bounds := img.Bounds()
+	width, height := bounds.Max.X, bounds.Max.Y
 
-	first_in_pair := true
-	previous_index := -1
+	previous_pixel := -1
 	var extracted_binstring strings.Builder
 
 	// Iterate through pixels in given order, based off: https://gist.github.com/Ge0rg3/282dd5671d755acbf13352a7ae8e2d5e
-	start_a, start_b := 0, 0
-	step_a, step_b := 1, 1
-	end_a, end_b := height, width
-	if direction != "row" {
-		end_a, end_b = width, height
-	}
-	for a := start_a; a < end_a; a += step_a {
-		// Flip direction of row/col if in zigzag pattern
-		if zigzag {
-			if a%2 == 1 {
-				start_b = end_b - 1
-				end_b, step_b = -1, -1
-			} else {
-				start_b = 0
-				step_b = 1
-				end_b = width
-				if direction != "row" {
-					end_b = height
-				}
-			}
+	for _, pixel := range pixelOrder(width, height, direction, zigzag) {
+		// If first in pixel pair, continue to next
+		if previous_pixel == -1 {
+			previous_pixel = pixel
+			continue
 		}
-		for b := start_b; b != end_b; b += step_b {
-			var index int
-			if direction == "row" {
-				index = ((a*width)+b)*values_per_pixel + rgba_index
-			} else {
-				index = (b * width * values_per_pixel) + a + rgba_index
-			}
-			// Iteration process now complete. PVD logic per pixel now starts
-			if first_in_pair {
-				// If first in pixel pair, continue to next
-				previous_index = index
-				first_in_pair = false
-			} else {
-				// Get difference between current pixel and previous pixel
-				abs_pixel_difference := Abs(int(pix_arr[previous_index]) - int(pix_arr[index]))
-				// Find minimum range and number of embeddable bits using range table
-				min_range, bit_count := checkRangeTable(range_table, abs_pixel_difference)
-				// Extract binary from difference
-				secret := abs_pixel_difference - min_range
-				secret_binary := ZeroLeftPad(strconv.FormatInt(int64(secret), 2), bit_count)
-				extracted_binstring.WriteString(secret_binary)
-				first_in_pair = true
+		// Extract from each channel of the pair, in the order given
+		for _, channel := range channels {
+			prev_val := int(pix_arr[pvdPixelOffset(previous_pixel, width, values_per_pixel, direction, legacy)+channel])
+			curr_val := int(pix_arr[pvdPixelOffset(pixel, width, values_per_pixel, direction, legacy)+channel])
+			// Get difference between current pixel and previous pixel
+			abs_pixel_difference := Abs(prev_val - curr_val)
+			if lsb_bits > 0 && abs_pixel_difference <= lsb_threshold {
+				extracted_binstring.WriteString(extractLsbPair(prev_val, curr_val, lsb_bits))
+				continue
 			}
+			// Find minimum range and number of embeddable bits using range table
+			min_range, bit_count := checkRangeTable(range_table, abs_pixel_difference)
+			// Extract binary from difference
+			secret := abs_pixel_difference - min_range
+			secret_binary := ZeroLeftPad(strconv.FormatInt(int64(secret), 2), bit_count)
+			extracted_binstring.WriteString(secret_binary)
 		}
+		previous_pixel = -1
 	}
 	// Convert to bytes
 	output_bytes := BitstringToBytes(extracted_binstring.String())
 	return output_bytes, nil
 }
 
+func pvdPixelOffset(pixel int, width int, values_per_pixel int, direction string, legacy bool) int {
+	/*
+		Find where a pixel (y*width + x) starts in the pixel array. Legacy column
+		order kept the original indexing, which doesn't step x by the values per
+		pixel, so on RGBA images it walks the bytes of each row instead of pixels.
+	*/
+	if legacy && direction != "row" {
+		return (pixel/width)*width*values_per_pixel + pixel%width
+	}
+	return pixel * values_per_pixel
+}
+
+func pvdChannels(plane string, values_per_pixel int) ([]int, error) {
+	/*
+		Convert a list of planes (i.e., "RGB") to the channel indices to embed in,
+		in order. Greyscale images only have the one channel.
+	*/
+	if len(plane) == 0 {
+		return nil, errors.New("invalid plane given. Only R/G/B/A are valid")
+	}
+	if values_per_pixel == 1 {
+		return []int{0}, nil
+	}
+	channels := make([]int, len(plane))
+	for index := range plane {
+		channel, err := RgbaToInt(plane[index:])
+		if err != nil {
+			return nil, err
+		}
+		for _, existing := range channels[:index] {
+			if existing == channel {
+				return nil, fmt.Errorf("plane '%c' given more than once", plane[index])
+			}
+		}
+		channels[index] = channel
+	}
+	return channels, nil
+}
+
 func RgbaToInt(s string) (int, error) {
 	var colour int
 	// Get R/G/B/A from first char
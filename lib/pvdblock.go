@@ -0,0 +1,203 @@
+package lib
+
+import (
+	"fmt"
+	"image"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Block-based PVD schemes. Each pair is {ref_x, ref_y, other_x, other_y}
+// relative to the top-left of a block. The reference pixel is never changed,
+// so every difference within a block can be read back independently.
+var pvdBlockSchemes = map[string][][]int{
+	// 2x2 block: horizontal, vertical and diagonal differences from the top-left
+	"tri": {{0, 0, 1, 0}, {0, 0, 0, 1}, {0, 0, 1, 1}},
+	// 3x3 block: differences between the centre and its four neighbours
+	"four": {{1, 1, 1, 0}, {1, 1, 0, 1}, {1, 1, 2, 1}, {1, 1, 1, 2}},
+}
+
+func getPvdBlockScheme(scheme string) ([][]int, int, int, error) {
+	/*
+		Look up a block scheme and return its pairs along with the block width and height.
+	*/
+	pairs, ok := pvdBlockSchemes[scheme]
+	if !ok {
+		return nil, 0, 0, fmt.Errorf("invalid PVD scheme '%s'. Must be 'pair', 'tri' or 'four'", scheme)
+	}
+	block_width, block_height := 0, 0
+	for _, pair := range pairs {
+		for i := 0; i < 4; i += 2 {
+			if pair[i]+1 > block_width {
+				block_width = pair[i] + 1
+			}
+			if pair[i+1]+1 > block_height {
+				block_height = pair[i+1] + 1
+			}
+		}
+	}
+	return pairs, block_width, block_height, nil
+}
+
+func pixelOrder(width int, height int, direction string, zigzag bool) []int {
+	/*
+		List the indices (y*width + x) of a width*height grid in the order they
+		should be visited, either by "row" or "column", optionally in a zigzag pattern.
+	*/
+	order := make([]int, 0, width*height)
+	end_a, end_b := height, width
+	if direction != "row" {
+		end_a, end_b = width, height
+	}
+	for a := 0; a < end_a; a++ {
+		for i := 0; i < end_b; i++ {
+			b := i
+			// Flip direction of every other row/col if in zigzag pattern
+			if zigzag && a%2 == 1 {
+				b = end_b - 1 - i
+			}
+			if direction == "row" {
+				order = append(order, a*width+b)
+			} else {
+				order = append(order, b*width+a)
+			}
+		}
+	}
+	return order
+}
+
+func blockPairCapacity(range_table [][]int, ref int, other int) (int, int) {
+	/*
+		Find the lower bound of the range and the number of bits a reference/other
+		pixel pair can carry. A pair only carries data if every difference in its
+		range can be reached by moving the other pixel, which can be checked
+		identically on both embed and extract since the reference never changes.
+	*/
+	lower, bit_count := checkRangeTable(range_table, Abs(other-ref))
+	if bit_count == 0 {
+		return 0, 0
+	}
+	upper := lower + (1 << bit_count) - 1
+	if upper > ref && upper > 255-ref {
+		return 0, 0
+	}
+	return lower, bit_count
+}
+
+func EmbedPvdBlock(cover_img image.Image, range_table [][]int, secret_bits string, scheme string, direction string, zigzag bool, plane string, orient_key string) (image.Image, error) {
+	/*
+		Embed a binstring into an image using a block-based PVD scheme, where
+		each block's reference pixel is compared against several neighbours.
+		Blocks are visited in either "row" or "column" direction, optionally in zigzag pattern.
+		If orient_key is given, each block is randomly mirrored/transposed based off it.
+	*/
+	pairs, block_width, block_height, err := getPvdBlockScheme(scheme)
+	if err != nil {
+		return nil, err
+	}
+	new_img, pix_arr, values_per_pixel, err := newCanvas(cover_img)
+	if err != nil {
+		return nil, err
+	}
+	channels, err := pvdChannels(plane, values_per_pixel)
+	if err != nil {
+		return nil, err
+	}
+	bounds := cover_img.Bounds()
+	width, height := bounds.Max.X, bounds.Max.Y
+	blocks_x, blocks_y := width/block_width, height/block_height
+	var orient_rand *rand.Rand
+	if orient_key != "" {
+		orient_rand = keyRand(orient_key, "orient")
+	}
+
+	secret_position := 0
+	for _, block := range pixelOrder(blocks_x, blocks_y, direction, zigzag) {
+		block_x, block_y := (block%blocks_x)*block_width, (block/blocks_x)*block_height
+		block_pairs := pairs
+		if orient_rand != nil {
+			block_pairs = orientBlockPairs(pairs, block_width, orient_rand)
+		}
+		for _, pair := range block_pairs {
+			ref_pixel := (block_y+pair[1])*width + block_x + pair[0]
+			other_pixel := (block_y+pair[3])*width + block_x + pair[2]
+			for _, channel := range channels {
+				ref_index := ref_pixel*values_per_pixel + channel
+				other_index := other_pixel*values_per_pixel + channel
+				ref, other := int(pix_arr[ref_index]), int(pix_arr[other_index])
+				lower, bit_count := blockPairCapacity(range_table, ref, other)
+				if bit_count == 0 {
+					continue
+				}
+				int_to_embed, _ := strconv.ParseInt(nextBits(secret_bits, secret_position, bit_count), 2, 64)
+				new_difference := lower + int(int_to_embed)
+				// Keep the difference on the same side of the reference where possible
+				new_other := ref + new_difference
+				if other < ref || new_other > 255 {
+					new_other = ref - new_difference
+					if new_other < 0 {
+						new_other = ref + new_difference
+					}
+				}
+				pix_arr[other_index] = uint8(new_other)
+
+				secret_position += bit_count
+				if secret_position >= len(secret_bits) {
+					return new_img, nil
+				}
+			}
+		}
+	}
+	fmt.Printf("WARNING: Image too small with given secret -- only %d/%d bits embedded.\n", secret_position, len(secret_bits))
+	return new_img, nil
+}
+
+func ExtractPvdBlock(img image.Image, range_table [][]int, scheme string, direction string, zigzag bool, plane string, orient_key string) ([]byte, error) {
+	/*
+		Extract data embedded with EmbedPvdBlock.
+	*/
+	pairs, block_width, block_height, err := getPvdBlockScheme(scheme)
+	if err != nil {
+		return nil, err
+	}
+	_, pix_arr, values_per_pixel, err := newCanvas(img)
+	if err != nil {
+		return nil, err
+	}
+	channels, err := pvdChannels(plane, values_per_pixel)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Max.X, bounds.Max.Y
+	blocks_x, blocks_y := width/block_width, height/block_height
+	var orient_rand *rand.Rand
+	if orient_key != "" {
+		orient_rand = keyRand(orient_key, "orient")
+	}
+
+	var extracted_binstring strings.Builder
+	for _, block := range pixelOrder(blocks_x, blocks_y, direction, zigzag) {
+		block_x, block_y := (block%blocks_x)*block_width, (block/blocks_x)*block_height
+		block_pairs := pairs
+		if orient_rand != nil {
+			block_pairs = orientBlockPairs(pairs, block_width, orient_rand)
+		}
+		for _, pair := range block_pairs {
+			ref_pixel := (block_y+pair[1])*width + block_x + pair[0]
+			other_pixel := (block_y+pair[3])*width + block_x + pair[2]
+			for _, channel := range channels {
+				ref := int(pix_arr[ref_pixel*values_per_pixel+channel])
+				other := int(pix_arr[other_pixel*values_per_pixel+channel])
+				lower, bit_count := blockPairCapacity(range_table, ref, other)
+				if bit_count == 0 {
+					continue
+				}
+				secret := Abs(other-ref) - lower
+				extracted_binstring.WriteString(ZeroLeftPad(strconv.FormatInt(int64(secret), 2), bit_count))
+			}
+		}
+	}
+	return BitstringToBytes(extracted_binstring.String()), nil
+}
@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand"
+	"strconv"
+)
+
+func keyRand(key string, purpose string) *rand.Rand {
+	/*
+		Create a random source seeded from a passphrase, so both the embedding and
+		extracting side draw the same values. Each purpose gets its own stream.
+	*/
+	digest := sha256.Sum256([]byte(purpose + ":" + key))
+	return rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(digest[:8]))))
+}
+
+func RangeWidthsFromKey(key string) []string {
+	/*
+		Derive a randomised but valid range table from a passphrase. Like the
+		published tables, ranges are narrow for small differences and widen as
+		the difference grows, but the boundaries don't line up with them.
+	*/
+	r := keyRand(key, "range")
+	var range_widths []string
+	start := 0
+	for start < 256 {
+		// Find the powers of two allowed at this point in the table
+		min_width, max_width := 2, 8
+		for min_width*8 <= start {
+			min_width *= 2
+		}
+		for max_width*2 <= start {
+			max_width *= 2
+		}
+		var candidates []int
+		for width := min_width; width <= max_width && width <= 256-start; width *= 2 {
+			candidates = append(candidates, width)
+		}
+		// Fill the remainder if nothing fits
+		width := 2
+		if len(candidates) > 0 {
+			width = candidates[r.Intn(len(candidates))]
+		} else {
+			for width*2 <= 256-start {
+				width *= 2
+			}
+		}
+		range_widths = append(range_widths, strconv.Itoa(width))
+		start += width
+	}
+	return range_widths
+}
+
+func orientBlockPairs(pairs [][]int, block_size int, r *rand.Rand) [][]int {
+	/*
+		Randomly mirror and/or transpose a square block's pairs, changing which
+		neighbours are used as the reference and which directions are differenced.
+	*/
+	orientation := r.Intn(8)
+	oriented := make([][]int, len(pairs))
+	for index, pair := range pairs {
+		oriented[index] = make([]int, 4)
+		for i := 0; i < 4; i += 2 {
+			x, y := pair[i], pair[i+1]
+			if orientation&1 != 0 {
+				x = block_size - 1 - x
+			}
+			if orientation&2 != 0 {
+				y = block_size - 1 - y
+			}
+			if orientation&4 != 0 {
+				x, y = y, x
+			}
+			oriented[index][i], oriented[index][i+1] = x, y
+		}
+	}
+	return oriented
+}
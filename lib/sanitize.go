@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	jpeg "github.com/dsoprea/go-jpeg-image-structure/v2"
+)
+
+// Kept by default: needed to display the image correctly and rarely abused
+var SanitizeDefaultKeep = []string{"jfif", "adobe", "tRNS", "colour"}
+
+type SanitizeRemoval struct {
+	Category string
+	Name     string
+	Offset   int
+	Size     int
+}
+
+func Sanitize(data []byte, keep []string) ([]byte, []SanitizeRemoval, error) {
+	/*
+		Strip everything that could carry hidden data without affecting the pixels:
+		EXIF, XMP, IPTC, comments, ICC profiles, other APPn segments, PNG ancillary
+		chunks and trailing data. Anything whose category (or PNG chunk type) is in
+		keep is left alone. GIF and BMP only have trailing data removed.
+	*/
+	keep_set := make(map[string]bool)
+	for _, name := range keep {
+		keep_set[name] = true
+	}
+	end, err := FindImageEnd(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var removed []SanitizeRemoval
+	var output []byte
+	switch DetectFileType(data) {
+	case "jpeg":
+		output, removed, err = sanitizeJpeg(data[:end], keep_set)
+	case "png":
+		output, removed, err = sanitizePng(data[:end], keep_set)
+	default:
+		fmt.Println("WARNING: Only trailing data is removed from GIF and BMP images.")
+		output = data[:end]
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if end < len(data) {
+		if keep_set["trailing"] {
+			output = append(append([]byte{}, output...), data[end:]...)
+		} else {
+			removed = append(removed, SanitizeRemoval{Category: "trailing", Name: "trailing data", Offset: end, Size: len(data) - end})
+		}
+	}
+	return output, removed, nil
+}
+
+func JpegSegmentCategory(segment *jpeg.Segment) string {
+	/*
+		Name the kind of metadata a COM/APPn segment holds, or "" for segments
+		needed to decode the image.
+	*/
+	data := segment.Data
+	switch {
+	case segment.MarkerId == JpegMarkerCom:
+		return "comment"
+	case segment.MarkerId == JpegMarkerApp0 && (bytes.HasPrefix(data, []byte("JFIF\x00")) || bytes.HasPrefix(data, []byte("JFXX\x00"))):
+		return "jfif"
+	case segment.MarkerId == JpegMarkerApp0+1 && bytes.HasPrefix(data, []byte("Exif\x00")):
+		return "exif"
+	case segment.MarkerId == JpegMarkerApp0+1 && (bytes.HasPrefix(data, []byte(XmpJpegIdentifier)) || bytes.HasPrefix(data, []byte("http://ns.adobe.com/xmp/extension/\x00"))):
+		return "xmp"
+	case segment.MarkerId == JpegMarkerApp0+2 && bytes.HasPrefix(data, []byte("ICC_PROFILE\x00")):
+		return "icc"
+	case segment.MarkerId == JpegMarkerApp0+13:
+		return "iptc"
+	case segment.MarkerId == JpegMarkerApp0+14 && bytes.HasPrefix(data, []byte("Adobe")):
+		return "adobe"
+	case segment.MarkerId >= JpegMarkerApp0 && segment.MarkerId <= JpegMarkerApp0+15:
+		return "app"
+	}
+	return ""
+}
+
+func sanitizeJpeg(data []byte, keep_set map[string]bool) ([]byte, []SanitizeRemoval, error) {
+	sl, err := ParseJpegSegments(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var removed []SanitizeRemoval
+	var segments []*jpeg.Segment
+	for _, segment := range sl.Segments() {
+		category := JpegSegmentCategory(segment)
+		if category == "" || keep_set[category] || keep_set[JpegMarkerName(segment.MarkerId)] {
+			segments = append(segments, segment)
+			continue
+		}
+		removed = append(removed, SanitizeRemoval{Category: category, Name: JpegMarkerName(segment.MarkerId), Offset: segment.Offset, Size: len(segment.Data) + 4})
+	}
+	output, err := EncodeJpegSegments(jpeg.NewSegmentList(segments))
+	return output, removed, err
+}
+
+func PngChunkCategory(chunk PngChunk) string {
+	/*
+		Name the kind of metadata an ancillary chunk holds, or "" for critical chunks.
+	*/
+	if chunk.Type[0] < 'a' {
+		return ""
+	}
+	switch chunk.Type {
+	case "eXIf":
+		return "exif"
+	case "iCCP":
+		return "icc"
+	case "sRGB", "gAMA", "cHRM":
+		return "colour"
+	case "tEXt", "zTXt", "iTXt":
+		if keyword, _, err := ReadPngTextChunk(chunk); err == nil && keyword == XmpPngKeyword {
+			return "xmp"
+		}
+		return "text"
+	}
+	return "ancillary"
+}
+
+func sanitizePng(data []byte, keep_set map[string]bool) ([]byte, []SanitizeRemoval, error) {
+	chunks, err := ParsePngChunks(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(chunks) == 0 || chunks[0].Type != "IHDR" {
+		return nil, nil, errors.New("PNG doesn't start with an IHDR chunk")
+	}
+	var removed []SanitizeRemoval
+	var kept []PngChunk
+	offset := len(PngSignature)
+	for _, chunk := range chunks {
+		category := PngChunkCategory(chunk)
+		if category == "" || keep_set[category] || keep_set[chunk.Type] {
+			kept = append(kept, chunk)
+		} else {
+			removed = append(removed, SanitizeRemoval{Category: category, Name: chunk.Type, Offset: offset, Size: len(chunk.Data) + 12})
+		}
+		offset += len(chunk.Data) + 12
+	}
+	return EncodePngChunks(kept), removed, nil
+}
@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"math/cmplx"
+	"math/rand"
+)
+
+// Brightness range of the picture, from black to white, in decibels
+const (
+	spectrogramSynthesisRange = 60.0
+	spectrogramRenderRange    = 80.0
+)
+
+func fft(values []complex128, inverse bool) {
+	/*
+		In-place radix-2 fast Fourier transform. The length must be a power of two.
+		The inverse transform is not scaled.
+	*/
+	n := len(values)
+	// Bit-reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+	sign := -1.0
+	if inverse {
+		sign = 1.0
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := values[start+k], values[start+k+size/2]*w
+				values[start+k], values[start+k+size/2] = even+odd, even-odd
+				w *= step
+			}
+		}
+	}
+}
+
+func hannWindow(size int) []float64 {
+	window := make([]float64, size)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size))
+	}
+	return window
+}
+
+func SynthesiseSpectrogram(img image.Image, sample_rate int, duration float64, low_freq float64, high_freq float64) (*Wav, error) {
+	/*
+		Synthesise audio whose spectrogram shows the image, by inverse STFT. Each
+		column becomes a frame, and each row a band of frequencies between
+		low_freq (bottom) and high_freq (top), with brighter pixels louder on a
+		logarithmic scale. Each frequency starts at a random phase, then keeps it
+		running from frame to frame, so overlapping frames add up coherently.
+	*/
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, errors.New("image is empty")
+	}
+	if low_freq < 0 || high_freq <= low_freq || high_freq > float64(sample_rate)/2 {
+		return nil, errors.New("frequencies must satisfy 0 <= low < high <= half the sample rate")
+	}
+	hop := int(math.Round(duration * float64(sample_rate) / float64(width)))
+	if hop < 1 {
+		return nil, errors.New("duration too short for the image width")
+	}
+	// Frames overlap by 75%, and have at least enough bins for the image's rows
+	size := 64
+	for size < hop*4 || float64(size)*(high_freq-low_freq)/float64(sample_rate) < float64(height) {
+		size <<= 1
+	}
+	low_bin := int(math.Ceil(low_freq * float64(size) / float64(sample_rate)))
+	high_bin := int(math.Floor(high_freq * float64(size) / float64(sample_rate)))
+	if low_bin < 1 {
+		low_bin = 1
+	}
+	if high_bin >= size/2 {
+		high_bin = size/2 - 1
+	}
+
+	// Greyscale brightness of each pixel
+	brightness := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			brightness[y*width+x] = float64(color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y) / 255
+		}
+	}
+
+	window := hannWindow(size)
+	samples := make([]float64, (width-1)*hop+size)
+	spectrum := make([]complex128, size)
+	r := rand.New(rand.NewSource(1))
+	phases := make([]float64, size/2)
+	for bin := range phases {
+		phases[bin] = r.Float64() * 2 * math.Pi
+	}
+	for x := 0; x < width; x++ {
+		for i := range spectrum {
+			spectrum[i] = 0
+		}
+		for bin := low_bin; bin <= high_bin; bin++ {
+			// The top row is the highest frequency
+			row := 0
+			if high_bin > low_bin {
+				row = int(math.Round(float64(high_bin-bin) / float64(high_bin-low_bin) * float64(height-1)))
+			}
+			value := brightness[row*width+x]
+			phase := phases[bin] + 2*math.Pi*float64(bin*hop*x)/float64(size)
+			if value == 0 {
+				continue
+			}
+			amplitude := math.Pow(10, -(1-value)*spectrogramSynthesisRange/20)
+			spectrum[bin] = cmplx.Rect(amplitude, phase)
+			// Mirror so the frame is real
+			spectrum[size-bin] = cmplx.Conj(spectrum[bin])
+		}
+		fft(spectrum, true)
+		for i, value := range spectrum {
+			samples[x*hop+i] += real(value) * window[i]
+		}
+	}
+
+	// Trim the frames' outer halves to fit the duration, then normalise the loudest sample
+	samples = samples[(size-hop)/2 : (size-hop)/2+width*hop]
+	peak := 0.0
+	for _, sample := range samples {
+		peak = math.Max(peak, math.Abs(sample))
+	}
+	if peak > 0 {
+		for i := range samples {
+			samples[i] *= 0.9 / peak
+		}
+	}
+	return NewMonoWav(samples, sample_rate), nil
+}
+
+func RenderSpectrogram(wav *Wav, size int, hop int) (image.Image, error) {
+	/*
+		Render the spectrogram of audio (mixed to mono) as a greyscale image, with
+		time left to right and frequency from 0 (bottom) to half the sample rate
+		(top). Brightness covers the top 80 dB.
+	*/
+	if size < 2 || size&(size-1) != 0 {
+		return nil, errors.New("FFT size must be a power of two")
+	}
+	if hop < 1 {
+		return nil, errors.New("hop must be at least 1")
+	}
+	samples := wav.MonoSamples()
+	if len(samples) < size {
+		samples = append(samples, make([]float64, size-len(samples))...)
+	}
+	frames := (len(samples)-size)/hop + 1
+	height := size / 2
+
+	window := hannWindow(size)
+	levels := make([]float64, frames*height)
+	spectrum := make([]complex128, size)
+	loudest := math.Inf(-1)
+	for x := 0; x < frames; x++ {
+		for i := range spectrum {
+			spectrum[i] = complex(samples[x*hop+i]*window[i], 0)
+		}
+		fft(spectrum, false)
+		for bin := 0; bin < height; bin++ {
+			level := 20 * math.Log10(cmplx.Abs(spectrum[bin])+1e-12)
+			levels[(height-1-bin)*frames+x] = level
+			loudest = math.Max(loudest, level)
+		}
+	}
+
+	img := image.NewGray(image.Rect(0, 0, frames, height))
+	for i, level := range levels {
+		value := (level - loudest + spectrogramRenderRange) / spectrogramRenderRange
+		img.Pix[i] = uint8(math.Round(math.Max(0, math.Min(1, value)) * 255))
+	}
+	return img, nil
+}
@@ -0,0 +1,273 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var TextMethods = []string{"whitespace", "zerowidth"}
+
+// Zero-width characters carrying 0 and 1 bits
+const (
+	zeroWidthZero = '\u200b'
+	zeroWidthOne  = '\u200c'
+)
+
+// Characters that don't show when text is displayed
+var invisibleRuneNames = map[rune]string{
+	'\u00ad': "SOFT HYPHEN",
+	'\u034f': "COMBINING GRAPHEME JOINER",
+	'\u061c': "ARABIC LETTER MARK",
+	'\u115f': "HANGUL CHOSEONG FILLER",
+	'\u1160': "HANGUL JUNGSEONG FILLER",
+	'\u180e': "MONGOLIAN VOWEL SEPARATOR",
+	'\u200b': "ZERO WIDTH SPACE",
+	'\u200c': "ZERO WIDTH NON-JOINER",
+	'\u200d': "ZERO WIDTH JOINER",
+	'\u200e': "LEFT-TO-RIGHT MARK",
+	'\u200f': "RIGHT-TO-LEFT MARK",
+	'\u202a': "LEFT-TO-RIGHT EMBEDDING",
+	'\u202b': "RIGHT-TO-LEFT EMBEDDING",
+	'\u202c': "POP DIRECTIONAL FORMATTING",
+	'\u202d': "LEFT-TO-RIGHT OVERRIDE",
+	'\u202e': "RIGHT-TO-LEFT OVERRIDE",
+	'\u2060': "WORD JOINER",
+	'\u2061': "FUNCTION APPLICATION",
+	'\u2062': "INVISIBLE TIMES",
+	'\u2063': "INVISIBLE SEPARATOR",
+	'\u2064': "INVISIBLE PLUS",
+	'\u2066': "LEFT-TO-RIGHT ISOLATE",
+	'\u2067': "RIGHT-TO-LEFT ISOLATE",
+	'\u2068': "FIRST STRONG ISOLATE",
+	'\u2069': "POP DIRECTIONAL ISOLATE",
+	'\u3164': "HANGUL FILLER",
+	'\ufeff': "ZERO WIDTH NO-BREAK SPACE",
+	'\uffa0': "HALFWIDTH HANGUL FILLER",
+}
+
+// A suspicious character or run of whitespace found in a text
+type TextFinding struct {
+	Line, Column int
+	Description  string
+}
+
+func splitTextLines(text string) ([]string, []string) {
+	/*
+		Split text into lines, and the line ending of each ("\n", "\r\n" or "" for
+		the last line), so they can be put back as they were.
+	*/
+	var lines, endings []string
+	for {
+		index := strings.IndexByte(text, '\n')
+		if index < 0 {
+			lines, endings = append(lines, text), append(endings, "")
+			return lines, endings
+		}
+		line, ending := text[:index], "\n"
+		if strings.HasSuffix(line, "\r") {
+			line, ending = line[:len(line)-1], "\r\n"
+		}
+		lines, endings = append(lines, line), append(endings, ending)
+		text = text[index+1:]
+	}
+}
+
+func EmbedWhitespace(cover string, secret []byte, line_length int) string {
+	/*
+		SNOW-style whitespace steganography: after a tab marking the start of data,
+		each 3 bits become 0-7 spaces followed by a tab, appended to the ends of
+		lines while they stay within line_length characters (at least one group
+		per line). Existing trailing whitespace is removed first, and blank lines
+		are added if the cover runs out.
+	*/
+	lines, endings := splitTextLines(cover)
+	stripped := 0
+	for i, line := range lines {
+		trimmed := strings.TrimRight(line, " \t")
+		if trimmed != line {
+			stripped++
+		}
+		lines[i] = trimmed
+	}
+	if stripped > 0 {
+		fmt.Printf("WARNING: Removed existing trailing whitespace from %d lines.\n", stripped)
+	}
+	// The last line only has content if the cover didn't end with a newline
+	if lines[len(lines)-1] == "" && len(lines) > 1 {
+		lines, endings = lines[:len(lines)-1], endings[:len(endings)-1]
+	}
+
+	// Added lines end like the cover's first line
+	new_ending := "\n"
+	if endings[0] != "" {
+		new_ending = endings[0]
+	}
+
+	bits := BytesToBitstream(secret)
+	var out strings.Builder
+	for i := 0; i < len(lines) || len(bits) > 0; i++ {
+		line, ending := "", new_ending
+		if i < len(lines) {
+			line, ending = lines[i], endings[i]
+		}
+		if len(bits) > 0 {
+			length := len([]rune(line)) + 1
+			appended := "\t"
+			for len(bits) > 0 {
+				value := 0
+				for b := 0; b < 3; b++ {
+					value <<= 1
+					if b < len(bits) && bits[b] {
+						value |= 1
+					}
+				}
+				if len(appended) > 1 && length+value+1 > line_length {
+					break
+				}
+				appended += strings.Repeat(" ", value) + "\t"
+				length += value + 1
+				if len(bits) < 3 {
+					bits = nil
+				} else {
+					bits = bits[3:]
+				}
+			}
+			line += appended
+			if ending == "" {
+				ending = new_ending
+			}
+		}
+		out.WriteString(line + ending)
+	}
+	return out.String()
+}
+
+func ExtractWhitespace(text string) []byte {
+	/*
+		Read back the 3-bit groups of each line's trailing whitespace, ignoring
+		lines whose trailing whitespace doesn't start with the tab marker.
+	*/
+	var bits []bool
+	lines, _ := splitTextLines(text)
+	for _, line := range lines {
+		trailing := line[len(strings.TrimRight(line, " \t")):]
+		if !strings.HasPrefix(trailing, "\t") {
+			continue
+		}
+		// Each group is up to 7 spaces ended by a tab
+		groups := strings.Split(trailing[1:], "\t")
+		for _, group := range groups[:len(groups)-1] {
+			if len(group) > 7 || strings.Trim(group, " ") != "" {
+				break
+			}
+			value := len(group)
+			bits = append(bits, value&4 != 0, value&2 != 0, value&1 != 0)
+		}
+	}
+	// Drop padding from the last group
+	return BitstreamToBytes(bits[:len(bits)/8*8])
+}
+
+func EmbedZeroWidth(cover string, secret []byte) string {
+	/*
+		Hide each byte as eight zero-width characters, ZERO WIDTH SPACE for 0 and
+		ZERO WIDTH NON-JOINER for 1, after the end of a word. Bytes left over
+		once the words run out go after the last word.
+	*/
+	if strings.ContainsAny(cover, string([]rune{zeroWidthZero, zeroWidthOne})) {
+		fmt.Println("WARNING: Cover already contains zero-width characters, which will corrupt extraction.")
+	}
+	runes := []rune(cover)
+	// Word ends: a visible character followed by whitespace or the end
+	var word_ends []int
+	for i, r := range runes {
+		if !unicode.IsSpace(r) && (i+1 == len(runes) || unicode.IsSpace(runes[i+1])) {
+			word_ends = append(word_ends, i)
+		}
+	}
+	if len(word_ends) == 0 {
+		return zeroWidthEncode(secret) + cover
+	}
+	var out strings.Builder
+	secret_pos, word := 0, 0
+	for i, r := range runes {
+		out.WriteRune(r)
+		if word < len(word_ends) && word_ends[word] == i {
+			word++
+			if word == len(word_ends) {
+				// Everything left after the last word
+				out.WriteString(zeroWidthEncode(secret[secret_pos:]))
+				secret_pos = len(secret)
+			} else if secret_pos < len(secret) {
+				out.WriteString(zeroWidthEncode(secret[secret_pos : secret_pos+1]))
+				secret_pos++
+			}
+		}
+	}
+	return out.String()
+}
+
+func zeroWidthEncode(data []byte) string {
+	var s strings.Builder
+	for _, bit := range BytesToBitstream(data) {
+		if bit {
+			s.WriteRune(zeroWidthOne)
+		} else {
+			s.WriteRune(zeroWidthZero)
+		}
+	}
+	return s.String()
+}
+
+func ExtractZeroWidth(text string) []byte {
+	var bits []bool
+	for _, r := range text {
+		switch r {
+		case zeroWidthZero:
+			bits = append(bits, false)
+		case zeroWidthOne:
+			bits = append(bits, true)
+		}
+	}
+	return BitstreamToBytes(bits[:len(bits)/8*8])
+}
+
+func DetectTextStego(text string) []TextFinding {
+	/*
+		Find characters that don't show up when text is displayed (zero-width,
+		bidirectional controls, tags and other format characters), and trailing
+		whitespace, which are common ways of hiding data in text.
+	*/
+	var findings []TextFinding
+	lines, _ := splitTextLines(text)
+	for line_number, line := range lines {
+		column := 0
+		for _, r := range line {
+			column++
+			name, invisible := invisibleRuneNames[r]
+			if !invisible && r >= 0xe0000 && r <= 0xe007f {
+				name, invisible = "TAG CHARACTER", true
+			}
+			if !invisible && unicode.Is(unicode.Cf, r) {
+				name, invisible = "FORMAT CHARACTER", true
+			}
+			if r == '\ufeff' && line_number == 0 && column == 1 {
+				// A byte order mark is expected at the start
+				invisible = false
+			}
+			if invisible {
+				findings = append(findings, TextFinding{line_number + 1, column, fmt.Sprintf("U+%04X %s", r, name)})
+			}
+		}
+		trailing := line[len(strings.TrimRight(line, " \t")):]
+		if trailing != "" {
+			description := fmt.Sprintf("%d trailing spaces/tabs", len(trailing))
+			if strings.HasPrefix(trailing, "\t") && strings.Contains(trailing[1:], "\t") {
+				description += " (SNOW-style pattern)"
+			}
+			findings = append(findings, TextFinding{line_number + 1, len([]rune(line)) - len(trailing) + 1, description})
+		}
+	}
+	return findings
+}
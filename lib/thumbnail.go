@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+// Longest side of generated thumbnails, as in the EXIF recommendation of 160x120
+const ThumbnailMaxSize = 160
+
+func ExifThumbnail(raw_exif []byte) ([]byte, error) {
+	/*
+		Return the JPEG thumbnail stored in IFD1 of raw EXIF data, including
+		anything hidden after its EOI marker.
+	*/
+	index, err := CollectExif(raw_exif)
+	if err != nil {
+		return nil, err
+	}
+	ifd1 := index.RootIfd.NextIfd()
+	if ifd1 == nil {
+		return nil, errors.New("EXIF data has no IFD1, so no thumbnail")
+	}
+	return ifd1.Thumbnail()
+}
+
+func SetExifThumbnail(ib *exif.IfdBuilder, byte_order binary.ByteOrder, thumbnail []byte) error {
+	/*
+		Store thumbnail in IFD1 of an EXIF builder, creating IFD1 if needed.
+	*/
+	ifd1_ib, err := getOrCreateIfd1(ib, byte_order)
+	if err != nil {
+		return err
+	}
+	return ifd1_ib.SetThumbnail(thumbnail)
+}
+
+func getOrCreateIfd1(ib *exif.IfdBuilder, byte_order binary.ByteOrder) (*exif.IfdBuilder, error) {
+	/*
+		Return IFD1 of an EXIF builder, creating it if needed. A new IFD1 must use
+		the same byte order as the rest of the EXIF data.
+	*/
+	ifd1_ib, err := ib.NextIb()
+	if err != nil {
+		return nil, err
+	}
+	if ifd1_ib == nil {
+		im, err := exifcommon.NewIfdMappingWithStandard()
+		if err != nil {
+			return nil, err
+		}
+		ifd1_ib = exif.NewIfdBuilder(im, exif.NewTagIndex(), exifcommon.Ifd1StandardIfdIdentity, byte_order)
+		err = ib.SetNextIb(ifd1_ib)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ifd1_ib, nil
+}
+
+func EncodeThumbnail(img image.Image) ([]byte, error) {
+	/*
+		Scale an image down to thumbnail size, keeping its aspect ratio, and
+		encode it as a JPEG.
+	*/
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if width > ThumbnailMaxSize || height > ThumbnailMaxSize {
+		if width >= height {
+			width, height = ThumbnailMaxSize, height*ThumbnailMaxSize/width
+		} else {
+			width, height = width*ThumbnailMaxSize/height, ThumbnailMaxSize
+		}
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, ResizeImage(img, width, height), &jpeg.Options{Quality: 75})
+	return buf.Bytes(), err
+}
+
+func ResizeImage(img image.Image, width int, height int) *image.NRGBA {
+	/*
+		Resize an image by averaging the block of source pixels covered by each
+		destination pixel (nearest neighbour when enlarging).
+	*/
+	bounds := img.Bounds()
+	resized := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBAModel.Convert(img.At(sx, sy)).(color.NRGBA)
+					r, g, b, a = r+uint64(c.R), g+uint64(c.G), b+uint64(c.B), a+uint64(c.A)
+					count += 1
+				}
+			}
+			resized.SetNRGBA(x, y, color.NRGBA{uint8(r / count), uint8(g / count), uint8(b / count), uint8(a / count)})
+		}
+	}
+	return resized
+}
+
+func ThumbnailDifference(main_img image.Image, thumbnail_img image.Image) float64 {
+	/*
+		Compare a thumbnail against the main image scaled down to the same size,
+		as the mean absolute difference in luminance (0 identical, 255 opposite).
+		Edited or swapped images usually leave a stale, clearly different thumbnail.
+	*/
+	bounds := thumbnail_img.Bounds()
+	scaled := ResizeImage(main_img, bounds.Dx(), bounds.Dy())
+	var total float64
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			a := color.GrayModel.Convert(scaled.At(x, y)).(color.Gray).Y
+			b := color.GrayModel.Convert(thumbnail_img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			total += float64(Abs(int(a) - int(b)))
+		}
+	}
+	return total / float64(bounds.Dx()*bounds.Dy())
+}
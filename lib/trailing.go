@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+func FindImageEnd(data []byte) (int, error) {
+	/*
+		Find the offset just past the end of the image stream in PNG, JPEG, GIF
+		or BMP file data, i.e., where any appended data would start.
+	*/
+	switch DetectFileType(data) {
+	case "png":
+		return pngEnd(data)
+	case "jpeg":
+		return jpegEnd(data)
+	case "gif":
+		return gifEnd(data)
+	case "bmp":
+		return bmpEnd(data)
+	}
+	return 0, errors.New("unsupported file type. Must be PNG, JPEG, GIF or BMP")
+}
+
+func pngEnd(data []byte) (int, error) {
+	chunks, err := ParsePngChunks(data)
+	if err != nil {
+		return 0, err
+	}
+	end := len(PngSignature)
+	for _, chunk := range chunks {
+		end += 12 + len(chunk.Data)
+	}
+	return end, nil
+}
+
+func jpegEnd(data []byte) (int, error) {
+	/*
+		Walk JPEG segments from SOI, skipping over entropy-coded scan data, until EOI.
+	*/
+	pos := 2
+	for pos+1 < len(data) {
+		if data[pos] != 0xff {
+			return 0, fmt.Errorf("expected JPEG marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+		switch {
+		case marker == 0xff:
+			// Fill byte
+			pos += 1
+			continue
+		case marker == 0xd9:
+			return pos + 2, nil
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			// Standalone markers with no length
+			pos += 2
+			continue
+		}
+		if pos+4 > len(data) {
+			break
+		}
+		pos += 2 + int(binary.BigEndian.Uint16(data[pos+2:pos+4]))
+		if marker == 0xda {
+			// Skip scan data up to the next marker that isn't stuffing or a restart
+			for pos+1 < len(data) && !(data[pos] == 0xff && data[pos+1] != 0x00 && !(data[pos+1] >= 0xd0 && data[pos+1] <= 0xd7)) {
+				pos += 1
+			}
+		}
+	}
+	return 0, errors.New("JPEG ended before EOI marker")
+}
+
+func gifEnd(data []byte) (int, error) {
+	/*
+		Walk GIF blocks from the header until the trailer byte.
+	*/
+	if len(data) < 13 {
+		return 0, errors.New("GIF is too short")
+	}
+	pos := 13
+	// Skip global colour table
+	if data[10]&0x80 != 0 {
+		pos += 3 * (1 << (int(data[10]&0x07) + 1))
+	}
+	skipSubBlocks := func() error {
+		for pos < len(data) && data[pos] != 0 {
+			pos += 1 + int(data[pos])
+		}
+		if pos >= len(data) {
+			return errors.New("GIF ended before trailer")
+		}
+		pos += 1
+		return nil
+	}
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x3b:
+			return pos + 1, nil
+		case 0x21:
+			// Extension: introducer, label, then sub-blocks
+			pos += 2
+			if err := skipSubBlocks(); err != nil {
+				return 0, err
+			}
+		case 0x2c:
+			// Image descriptor, optional local colour table, LZW code size, then sub-blocks
+			if pos+10 > len(data) {
+				return 0, errors.New("GIF ended before trailer")
+			}
+			packed := data[pos+9]
+			pos += 10
+			if packed&0x80 != 0 {
+				pos += 3 * (1 << (int(packed&0x07) + 1))
+			}
+			pos += 1
+			if err := skipSubBlocks(); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, fmt.Errorf("unexpected GIF block 0x%02x at offset %d", data[pos], pos)
+		}
+	}
+	return 0, errors.New("GIF ended before trailer")
+}
+
+func bmpEnd(data []byte) (int, error) {
+	/*
+		BMP files declare their own size in the file header. Some writers leave it
+		0, or too small for the pixels, in which case the end of the pixel data is
+		used instead, or the whole file if that can't be worked out.
+	*/
+	if len(data) < 6 {
+		return 0, errors.New("BMP is too short")
+	}
+	size := int(binary.LittleEndian.Uint32(data[2:6]))
+	if size > len(data) {
+		return 0, fmt.Errorf("BMP header declares %d bytes, but file is only %d", size, len(data))
+	}
+	header, err := readBmpHeader(data)
+	if err != nil {
+		// Compressed, say, so only trust a size that goes past the headers
+		if len(data) < bmpFileHeaderSize || size <= int(binary.LittleEndian.Uint32(data[10:14])) {
+			return len(data), nil
+		}
+		return size, nil
+	}
+	raster_end := header.pixel_offset + (header.width*header.bpp+31)/32*4*header.height
+	if size < raster_end {
+		if raster_end > len(data) {
+			return len(data), nil
+		}
+		return raster_end, nil
+	}
+	return size, nil
+}
+
+func AppendTrailingData(data []byte, payload []byte) ([]byte, error) {
+	/*
+		Append payload after the image's end marker, keeping the image valid.
+		Any existing trailing data is replaced.
+	*/
+	end, err := FindImageEnd(data)
+	if err != nil {
+		return nil, err
+	}
+	if end < len(data) {
+		fmt.Printf("WARNING: Replacing %d bytes already trailing the image.\n", len(data)-end)
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:end])
+	buf.Write(payload)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,251 @@
+package lib
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+)
+
+// WAV format tags
+const (
+	wavFormatPcm        = 1
+	wavFormatExtensible = 0xfffe
+)
+
+// A PCM WAV file. Data is the whole file, so headers and other chunks are
+// written back exactly as they were read.
+type Wav struct {
+	Data          []byte
+	Channels      int
+	SampleRate    int
+	BitsPerSample int
+	samples_start int
+	samples_end   int
+}
+
+func ReadWav(data []byte) (*Wav, error) {
+	/*
+		Parse a RIFF WAVE file holding 8, 16 or 24-bit PCM, mono or stereo.
+	*/
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, errors.New("file is not a WAV")
+	}
+	wav := &Wav{Data: data}
+	format_found := false
+	pos := 12
+	for pos+8 <= len(data) {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		body_start := pos + 8
+		body_end := body_start + size
+		if body_end > len(data) || body_end < body_start {
+			// Streamed WAVs may not have the real size filled in
+			body_end = len(data)
+		}
+		switch id {
+		case "fmt ":
+			body := data[body_start:body_end]
+			if len(body) < 16 {
+				return nil, errors.New("invalid WAV fmt chunk")
+			}
+			format_tag := int(binary.LittleEndian.Uint16(body))
+			if format_tag == wavFormatExtensible && len(body) >= 26 {
+				// The sub-format GUID starts with the actual format tag
+				format_tag = int(binary.LittleEndian.Uint16(body[24:]))
+			}
+			if format_tag != wavFormatPcm {
+				return nil, fmt.Errorf("unsupported WAV format tag %d. Only PCM WAVs are supported", format_tag)
+			}
+			wav.Channels = int(binary.LittleEndian.Uint16(body[2:]))
+			wav.SampleRate = int(binary.LittleEndian.Uint32(body[4:]))
+			wav.BitsPerSample = int(binary.LittleEndian.Uint16(body[14:]))
+			if wav.Channels != 1 && wav.Channels != 2 {
+				return nil, fmt.Errorf("unsupported WAV with %d channels. Only mono and stereo are supported", wav.Channels)
+			}
+			if wav.BitsPerSample != 8 && wav.BitsPerSample != 16 && wav.BitsPerSample != 24 {
+				return nil, fmt.Errorf("unsupported WAV with %d-bit samples. Only 8, 16 and 24-bit are supported", wav.BitsPerSample)
+			}
+			format_found = true
+		case "data":
+			if !format_found {
+				return nil, errors.New("WAV data chunk comes before the fmt chunk")
+			}
+			wav.samples_start, wav.samples_end = body_start, body_end
+			return wav, nil
+		}
+		// Chunks are padded to an even size
+		pos = body_end + size%2
+	}
+	return nil, errors.New("WAV has no data chunk")
+}
+
+func OpenWav(wav_path string) (*Wav, error) {
+	data, err := ioutil.ReadFile(wav_path)
+	if err != nil {
+		return nil, err
+	}
+	return ReadWav(data)
+}
+
+func SaveWav(wav *Wav, output_path string) error {
+	return ioutil.WriteFile(output_path, wav.Data, 0644)
+}
+
+func (wav *Wav) frameCount() int {
+	return (wav.samples_end - wav.samples_start) / (wav.Channels * wav.BitsPerSample / 8)
+}
+
+func (wav *Wav) MonoSamples() []float64 {
+	/*
+		Samples scaled to -1 to 1, with the channels averaged.
+	*/
+	samples := wav.Data[wav.samples_start:wav.samples_end]
+	sample_bytes := wav.BitsPerSample / 8
+	mono := make([]float64, wav.frameCount())
+	for frame := range mono {
+		total := 0.0
+		for channel := 0; channel < wav.Channels; channel++ {
+			sample := samples[(frame*wav.Channels+channel)*sample_bytes:]
+			switch wav.BitsPerSample {
+			case 8:
+				// 8-bit samples are unsigned
+				total += (float64(sample[0]) - 128) / 128
+			case 16:
+				total += float64(int16(binary.LittleEndian.Uint16(sample))) / 32768
+			case 24:
+				value := int32(sample[0]) | int32(sample[1])<<8 | int32(int8(sample[2]))<<16
+				total += float64(value) / 8388608
+			}
+		}
+		mono[frame] = total / float64(wav.Channels)
+	}
+	return mono
+}
+
+func NewMonoWav(samples []float64, sample_rate int) *Wav {
+	/*
+		Build a 16-bit mono PCM WAV from samples between -1 and 1, clipping any
+		outside that range.
+	*/
+	data_size := len(samples) * 2
+	data := make([]byte, 44+data_size)
+	copy(data[0:], "RIFF")
+	binary.LittleEndian.PutUint32(data[4:], uint32(36+data_size))
+	copy(data[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(data[16:], 16)
+	binary.LittleEndian.PutUint16(data[20:], wavFormatPcm)
+	binary.LittleEndian.PutUint16(data[22:], 1)
+	binary.LittleEndian.PutUint32(data[24:], uint32(sample_rate))
+	binary.LittleEndian.PutUint32(data[28:], uint32(sample_rate*2))
+	binary.LittleEndian.PutUint16(data[32:], 2)
+	binary.LittleEndian.PutUint16(data[34:], 16)
+	copy(data[36:], "data")
+	binary.LittleEndian.PutUint32(data[40:], uint32(data_size))
+	for i, sample := range samples {
+		value := math.Round(sample * 32767)
+		value = math.Max(-32768, math.Min(32767, value))
+		binary.LittleEndian.PutUint16(data[44+i*2:], uint16(int16(value)))
+	}
+	return &Wav{Data: data, Channels: 1, SampleRate: sample_rate, BitsPerSample: 16, samples_start: 44, samples_end: 44 + data_size}
+}
+
+func SampleplaneArgsToArray(sampleplane_args []string, channels int, bits_per_sample int) ([][]interface{}, error) {
+	/*
+		Take input string, such as "L0 L1 R0" and convert to array ready for
+		stego operations, i.e., "L0 R2" -> [[Left, 0], [Right, 2]]. Bit 0 is the
+		least significant bit of a sample.
+	*/
+	var channel_operations = make([][]interface{}, len(sampleplane_args))
+	for index, value := range sampleplane_args {
+		if len(value) < 2 {
+			return nil, fmt.Errorf("invalid sample plane string '%s'. Should be in format 'L0', 'R3' etc", value)
+		}
+		var channel int
+		// Get L/R from first char
+		switch value[0] {
+		case 'L':
+			channel = 0
+		case 'R':
+			channel = 1
+		default:
+			return nil, fmt.Errorf("invalid channel input '%c' (must be L/R)", value[0])
+		}
+		if channel >= channels {
+			return nil, errors.New("mono WAVs only have one channel. Use L planes, i.e., \"L0\"")
+		}
+		bitpos, err := strconv.Atoi(value[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample plane string '%s'. Should be in format 'L0', 'R3' etc", value)
+		}
+		if bitpos < 0 || bitpos >= bits_per_sample {
+			return nil, fmt.Errorf("invalid bit position '%d'. Must be an int between 0-%d for %d-bit samples", bitpos, bits_per_sample-1, bits_per_sample)
+		}
+		channel_operations[index] = []interface{}{channel, bitpos}
+	}
+	return channel_operations, nil
+}
+
+func EmbedWavLsb(sampleplane_args []string, secret_bitstream []bool, cover *Wav) (*Wav, error) {
+	// Parse sample plane operation input
+	channel_operations, err := SampleplaneArgsToArray(sampleplane_args, cover.Channels, cover.BitsPerSample)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy the file, only changing sample bytes
+	new_wav := *cover
+	new_wav.Data = append([]byte(nil), cover.Data...)
+	samples := new_wav.Data[new_wav.samples_start:new_wav.samples_end]
+	sample_bytes := cover.BitsPerSample / 8
+	frame_bytes := cover.Channels * sample_bytes
+	if len(secret_bitstream) == 0 {
+		return &new_wav, nil
+	}
+
+	// Iterate through all frames and embed data
+	secret_pos := 0
+	for frame := 0; frame < new_wav.frameCount(); frame++ {
+		for _, embed_instruction := range channel_operations {
+			channel := embed_instruction[0].(int)
+			bit_pos := embed_instruction[1].(int)
+			// Samples are little-endian
+			index := frame*frame_bytes + channel*sample_bytes + bit_pos/8
+			if secret_bitstream[secret_pos] {
+				samples[index] |= 1 << uint(bit_pos%8)
+			} else {
+				samples[index] &^= 1 << uint(bit_pos%8)
+			}
+			secret_pos += 1
+			if secret_pos == len(secret_bitstream) {
+				return &new_wav, nil
+			}
+		}
+	}
+	fmt.Printf("WARNING: Audio too short with given sample plane inputs -- only %d/%d bits embedded.\n", secret_pos, len(secret_bitstream))
+	return &new_wav, nil
+}
+
+func ExtractWavLsb(sampleplane_args []string, input *Wav) ([]bool, error) {
+	// Parse sample plane operation input
+	channel_operations, err := SampleplaneArgsToArray(sampleplane_args, input.Channels, input.BitsPerSample)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := input.Data[input.samples_start:input.samples_end]
+	sample_bytes := input.BitsPerSample / 8
+	frame_bytes := input.Channels * sample_bytes
+	bitstream := make([]bool, 0, input.frameCount()*len(channel_operations))
+	for frame := 0; frame < input.frameCount(); frame++ {
+		for _, embed_instruction := range channel_operations {
+			channel := embed_instruction[0].(int)
+			bit_pos := embed_instruction[1].(int)
+			index := frame*frame_bytes + channel*sample_bytes + bit_pos/8
+			bitstream = append(bitstream, HasBit(samples[index], bit_pos%8))
+		}
+	}
+	return bitstream, nil
+}
@@ -0,0 +1,255 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	jpeg "github.com/dsoprea/go-jpeg-image-structure/v2"
+)
+
+const (
+	// Identifier at the start of a JPEG APP1 segment holding XMP
+	XmpJpegIdentifier = "http://ns.adobe.com/xap/1.0/\x00"
+	// Keyword of the PNG iTXt chunk holding XMP
+	XmpPngKeyword = "XML:com.adobe.xmp"
+	// Default namespace and property used for payloads
+	XmpStegogoNamespace = "http://ns.stegogo.dev/1.0/"
+	XmpStegogoProperty  = "Data"
+	xmpRdfNamespace     = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+// A single XMP property, with its namespace URI and (for arrays and structs) its parent
+type XmpProperty struct {
+	Namespace string `json:"namespace"`
+	Path      string `json:"path"`
+	Value     string `json:"value"`
+}
+
+func ReadXmp(data []byte) ([]byte, error) {
+	/*
+		Return the XMP packet of a JPEG (APP1) or PNG (iTXt) file.
+	*/
+	switch DetectFileType(data) {
+	case "jpeg":
+		sl, err := ParseJpegSegments(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, segment := range sl.Segments() {
+			if segment.MarkerId == JpegMarkerApp0+1 && bytes.HasPrefix(segment.Data, []byte(XmpJpegIdentifier)) {
+				return segment.Data[len(XmpJpegIdentifier):], nil
+			}
+		}
+	case "png":
+		chunks, err := ParsePngChunks(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, chunk := range chunks {
+			if chunk.Type != "iTXt" {
+				continue
+			}
+			keyword, text, err := ReadPngTextChunk(chunk)
+			if err == nil && keyword == XmpPngKeyword {
+				return text, nil
+			}
+		}
+	default:
+		return nil, errors.New("unsupported file type. Must be JPEG or PNG")
+	}
+	return nil, errors.New("no XMP packet found")
+}
+
+func WriteXmp(data []byte, packet []byte) ([]byte, error) {
+	/*
+		Set the XMP packet of a JPEG or PNG file, replacing any existing one. In
+		JPEGs it goes in an APP1 segment after any EXIF, in PNGs in an uncompressed
+		iTXt chunk before the image data, as the XMP specification recommends.
+	*/
+	switch DetectFileType(data) {
+	case "jpeg":
+		if len(XmpJpegIdentifier)+len(packet) > JpegMaxSegmentData {
+			return nil, fmt.Errorf("XMP packet is %d bytes, but must fit in a %d byte APP1 segment", len(packet), JpegMaxSegmentData-len(XmpJpegIdentifier))
+		}
+		sl, err := ParseJpegSegments(data)
+		if err != nil {
+			return nil, err
+		}
+		var segments []*jpeg.Segment
+		for _, segment := range sl.Segments() {
+			if segment.MarkerId == JpegMarkerApp0+1 && bytes.HasPrefix(segment.Data, []byte(XmpJpegIdentifier)) {
+				continue
+			}
+			segments = append(segments, segment)
+		}
+		xmp_segment := &jpeg.Segment{MarkerId: JpegMarkerApp0 + 1, MarkerName: "APP1", Data: append([]byte(XmpJpegIdentifier), packet...)}
+		sl = InsertJpegSegments(jpeg.NewSegmentList(segments), []*jpeg.Segment{xmp_segment})
+		return EncodeJpegSegments(sl)
+	case "png":
+		chunks, err := ParsePngChunks(data)
+		if err != nil {
+			return nil, err
+		}
+		image_end, err := FindImageEnd(data)
+		if err != nil {
+			return nil, err
+		}
+		// Uncompressed, with no language tag or translated keyword
+		var buf bytes.Buffer
+		buf.WriteString(XmpPngKeyword)
+		buf.Write([]byte{0, 0, 0, 0, 0})
+		buf.Write(packet)
+		var new_chunks []PngChunk
+		for _, chunk := range chunks {
+			if chunk.Type == "iTXt" {
+				if keyword, _, err := ReadPngTextChunk(chunk); err == nil && keyword == XmpPngKeyword {
+					continue
+				}
+			}
+			if chunk.Type == "IDAT" && buf.Len() > 0 {
+				new_chunks = append(new_chunks, PngChunk{Type: "iTXt", Data: buf.Bytes()})
+				buf.Reset()
+			}
+			new_chunks = append(new_chunks, chunk)
+		}
+		// Keep anything after IEND
+		return append(EncodePngChunks(new_chunks), data[image_end:]...), nil
+	}
+	return nil, errors.New("unsupported file type. Must be JPEG or PNG")
+}
+
+func NewXmpPacket() []byte {
+	/*
+		An empty XMP packet, ready for properties to be added.
+	*/
+	return []byte(`<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="` + xmpRdfNamespace + `">
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`)
+}
+
+func SetXmpProperty(packet []byte, namespace string, prefix string, property string, value string) ([]byte, error) {
+	/*
+		Add a simple property to an XMP packet in its own rdf:Description, removing
+		any previous one written by this function for the same namespace.
+	*/
+	if packet == nil {
+		packet = NewXmpPacket()
+	}
+	if !regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`).MatchString(prefix + property) {
+		return nil, fmt.Errorf("invalid XMP prefix '%s' or property '%s'", prefix, property)
+	}
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(namespace))
+	namespace_attr := fmt.Sprintf(`xmlns:%s="%s"`, prefix, escaped.String())
+
+	// Remove the description we previously added
+	existing := regexp.MustCompile(`(?s)\s*<rdf:Description rdf:about="" ` + regexp.QuoteMeta(namespace_attr) + `>.*?</rdf:Description>`)
+	packet = existing.ReplaceAll(packet, nil)
+
+	end := bytes.LastIndex(packet, []byte("</rdf:RDF>"))
+	if end == -1 {
+		return nil, errors.New("XMP packet has no rdf:RDF element")
+	}
+	escaped.Reset()
+	xml.EscapeText(&escaped, []byte(value))
+	description := fmt.Sprintf("  <rdf:Description rdf:about=\"\" %s>\n   <%s:%s>%s</%s:%s>\n  </rdf:Description>\n ",
+		namespace_attr, prefix, property, escaped.String(), prefix, property)
+	var buf bytes.Buffer
+	buf.Write(bytes.TrimRight(packet[:end], " "))
+	buf.WriteString(description)
+	buf.Write(packet[end:])
+	return buf.Bytes(), nil
+}
+
+func XmpProperties(packet []byte) ([]XmpProperty, error) {
+	/*
+		List every property with a value in an XMP packet, whether written as an
+		element or in the attribute shorthand. Array items and struct fields are
+		listed with the path of their parent.
+	*/
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	var properties []XmpProperty
+	var names []xml.Name
+	var text strings.Builder
+	isRdf := func(name xml.Name) bool {
+		return name.Space == xmpRdfNamespace || name.Space == "adobe:ns:meta/"
+	}
+	pathOf := func(local string) string {
+		var parts []string
+		for _, name := range names {
+			parts = append(parts, name.Local)
+		}
+		if local != "" {
+			parts = append(parts, local)
+		}
+		return strings.Join(parts, "/")
+	}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return properties, nil
+		}
+		if err != nil {
+			return properties, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			text.Reset()
+			if !isRdf(t.Name) {
+				names = append(names, t.Name)
+			}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "" || attr.Name.Space == "xmlns" || attr.Name.Space == "http://www.w3.org/XML/1998/namespace" || isRdf(attr.Name) {
+					continue
+				}
+				properties = append(properties, XmpProperty{Namespace: attr.Name.Space, Path: pathOf(attr.Name.Local), Value: attr.Value})
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+			text.Reset()
+			if !isRdf(t.Name) {
+				if value != "" {
+					properties = append(properties, XmpProperty{Namespace: t.Name.Space, Path: pathOf(""), Value: value})
+				}
+				names = names[:len(names)-1]
+			} else if t.Name.Local == "li" && value != "" && len(names) > 0 {
+				// Array item, belonging to the enclosing property
+				properties = append(properties, XmpProperty{Namespace: names[len(names)-1].Space, Path: pathOf("li"), Value: value})
+			}
+		}
+	}
+}
+
+func EmbedXmpPayload(packet []byte, namespace string, prefix string, property string, payload []byte) ([]byte, error) {
+	/*
+		Store payload as base64 in a custom namespace property of an XMP packet.
+	*/
+	return SetXmpProperty(packet, namespace, prefix, property, base64.StdEncoding.EncodeToString(payload))
+}
+
+func ExtractXmpPayload(packet []byte, namespace string, property string) ([]byte, error) {
+	/*
+		Find a property written by EmbedXmpPayload and decode it.
+	*/
+	properties, err := XmpProperties(packet)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range properties {
+		if p.Namespace == namespace && (p.Path == property || strings.HasSuffix(p.Path, "/"+property)) {
+			return base64.StdEncoding.DecodeString(p.Value)
+		}
+	}
+	return nil, fmt.Errorf("no '%s' property in namespace '%s' found", property, namespace)
+}